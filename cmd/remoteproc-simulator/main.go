@@ -3,9 +3,13 @@ package main
 import (
 	"fmt"
 	"log"
+	"math/rand/v2"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/arm/remoteproc-simulator/pkg/simulator"
 	"github.com/spf13/cobra"
@@ -20,6 +24,45 @@ func main() {
 	var rootDir string
 	var index uint
 	var name string
+	var firmware string
+	var autoBoot bool
+	var watcher string
+	var pollInterval time.Duration
+	var loadRate uint64
+	var segmentLoadRate uint64
+	var segmentLoadOverhead time.Duration
+	var kernelRelease string
+	var firmwareFallback bool
+	var firmwareFallbackTimeout time.Duration
+	var charDevice bool
+	var deviceTree bool
+	var compatible string
+	var memoryRegions []string
+	var mailboxes []string
+	var deviceMemory bool
+	var vrings bool
+	var doorbell bool
+	var doorbellDelay time.Duration
+	var doorbellDropRate float64
+	var suspendLatency time.Duration
+	var resumeLatency time.Duration
+	var autosuspendDelay time.Duration
+	var watchdogTimeout time.Duration
+	var heartbeatEndpoint uint32
+	var scenarioPath string
+	var chaos bool
+	var chaosSeed uint64
+	var chaosCrashRate float64
+	var chaosBootFailureRate float64
+	var chaosSlowBootRate float64
+	var chaosStopFailureRate float64
+	var chaosSlowBootDelay time.Duration
+	var chaosLogPath string
+	var stateFile string
+	var cleanup string
+	var keepArtifactsOnFailure bool
+	var adoptExisting bool
+	var shutdownTimeout time.Duration
 	var showVersion bool
 
 	rootCmd := &cobra.Command{
@@ -44,39 +87,206 @@ Example usage:
   cat /tmp/fake-root/sys/class/remoteproc/remoteproc0/state  # Shows 'offline'
 	`,
 		Version: fmt.Sprintf("%s (commit: %s)", version, commit),
-		RunE: func(cmd *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			var sims []*simulator.Remoteproc
 			if !cmd.Flags().Changed("root-dir") {
-				tmpDir, err := os.MkdirTemp("", "remoteproc-simulator-*")
+				tmpDir, mkdirErr := os.MkdirTemp("", "remoteproc-simulator-*")
+				if mkdirErr != nil {
+					return mkdirErr
+				}
+				rootDir = tmpDir
+				// The temporary root is the simulator's own, so it goes
+				// unless its content is to be kept.
+				defer func() {
+					failed := err != nil || slices.ContainsFunc(sims, (*simulator.Remoteproc).Failed)
+					if simulator.CleanupPolicy(cleanup) == simulator.CleanupKeep || keepArtifactsOnFailure && failed {
+						log.Printf("Keeping %s", tmpDir)
+						return
+					}
+					os.RemoveAll(tmpDir)
+				}()
+			}
+
+			var regions []simulator.MemoryRegion
+			for _, s := range memoryRegions {
+				region, err := simulator.ParseMemoryRegion(s)
 				if err != nil {
 					return err
 				}
-				rootDir = tmpDir
+				regions = append(regions, region)
 			}
 
-			sim, err := simulator.NewRemoteproc(
-				simulator.Config{
-					RootDir: rootDir,
-					Index:   index,
-					Name:    name,
+			base := simulator.Config{
+				RootDir:         rootDir,
+				Index:           index,
+				Name:            name,
+				DefaultFirmware: firmware,
+				AutoBoot:        autoBoot,
+				Watcher:         watcher,
+				PollInterval:    pollInterval,
+				LoadTime: simulator.LoadTime{
+					BytesPerSecond:        loadRate,
+					SegmentBytesPerSecond: segmentLoadRate,
+					SegmentOverhead:       segmentLoadOverhead,
 				},
-			)
-			if err != nil {
-				return fmt.Errorf("failed to start simulator: %v", err)
+
+				KernelRelease:           kernelRelease,
+				FirmwareFallback:        firmwareFallback,
+				FirmwareFallbackTimeout: firmwareFallbackTimeout,
+				CharDevice:              charDevice,
+				DeviceTree:              deviceTree,
+				Compatible:              compatible,
+				MemoryRegions:           regions,
+				Mailboxes:               mailboxes,
+				DeviceMemory:            deviceMemory,
+				Vrings:                  vrings,
+				Doorbell:                doorbell,
+				DoorbellFaults: simulator.DoorbellFaults{
+					Delay:    doorbellDelay,
+					DropRate: doorbellDropRate,
+				},
+				SuspendLatency:    suspendLatency,
+				ResumeLatency:     resumeLatency,
+				AutosuspendDelay:  autosuspendDelay,
+				WatchdogTimeout:   watchdogTimeout,
+				HeartbeatEndpoint: heartbeatEndpoint,
+
+				AdoptExisting:          adoptExisting,
+				Cleanup:                simulator.CleanupPolicy(cleanup),
+				KeepArtifactsOnFailure: keepArtifactsOnFailure,
+			}
+			if stateFile != "" {
+				store, err := simulator.NewStateStore(stateFile)
+				if err != nil {
+					return err
+				}
+				base.StateStore = store
+			}
+			if chaos {
+				if !cmd.Flags().Changed("chaos-seed") {
+					chaosSeed = rand.Uint64()
+				}
+				base.Chaos = simulator.Chaos{
+					Seed:            chaosSeed,
+					CrashRate:       chaosCrashRate,
+					BootFailureRate: chaosBootFailureRate,
+					SlowBootRate:    chaosSlowBootRate,
+					StopFailureRate: chaosStopFailureRate,
+					SlowBootDelay:   chaosSlowBootDelay,
+				}
+				if chaosLogPath != "" {
+					chaosLog, err := simulator.NewChaosLog(chaosLogPath)
+					if err != nil {
+						return err
+					}
+					base.Chaos.Log = chaosLog
+				}
+				log.Printf("Chaos enabled, rerun with --chaos-seed %d to reproduce", chaosSeed)
 			}
-			defer sim.Close()
 
+			configs := []simulator.Config{base}
+			if scenarioPath != "" {
+				scenario, err := simulator.LoadScenario(scenarioPath)
+				if err != nil {
+					return err
+				}
+				configs = configs[:0]
+				for _, instance := range scenario.Instances {
+					configs = append(configs, instance.Config(base))
+				}
+			}
+
+			defer func() {
+				for _, sim := range slices.Backward(sims) {
+					sim.Close()
+				}
+			}()
+			for _, config := range configs {
+				sim, err := simulator.NewRemoteproc(config)
+				if err != nil {
+					return fmt.Errorf("failed to start simulator: %v", err)
+				}
+				sims = append(sims, sim)
+			}
+
+			signals := []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+			if reloadSignal != nil {
+				signals = append(signals, reloadSignal, dumpSignal)
+			}
 			sigChan := make(chan os.Signal, 1)
-			signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-			<-sigChan
+			signal.Notify(sigChan, signals...)
+			for sig := <-sigChan; sig == reloadSignal || sig == dumpSignal; sig = <-sigChan {
+				switch {
+				case sig == dumpSignal:
+					dumpSnapshots(sims)
+				case scenarioPath == "":
+					log.Printf("Nothing to reload without --scenario")
+				default:
+					sims = reloadScenario(scenarioPath, base, sims, shutdownTimeout)
+				}
+			}
 			log.Println("Received shutdown signal")
 
+			// Instances are stopped in the reverse order of their creation,
+			// so that a remote processor goes after those depending on it,
+			// and all of them are offline before any file is removed.
+			var failed []string
+			for _, sim := range slices.Backward(sims) {
+				if err := sim.Shutdown(shutdownTimeout); err != nil {
+					log.Printf("Shutdown failed: %s", err)
+					failed = append(failed, sim.Name())
+				}
+			}
+			if len(failed) > 0 {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("failed to stop %s", strings.Join(failed, ", "))
+			}
 			return nil
 		},
 	}
 
 	rootCmd.Flags().UintVar(&index, "index", 0, "is the N in /sys/class/remoteproc/remoteprocN/.../ (default 0)")
 	rootCmd.Flags().StringVar(&name, "name", "dsp0", "remote processor name written to /sys/class/remoteproc/.../name")
+	rootCmd.Flags().StringVar(&firmware, "firmware", "", "firmware selected at start up, like a driver's default firmware-name")
+	rootCmd.Flags().BoolVar(&autoBoot, "auto-boot", false, "boot the remote processor at start up (default firmware: rproc-<name>-fw)")
 	rootCmd.Flags().StringVar(&rootDir, "root-dir", "", "location where /sys and /lib will be created")
+	rootCmd.Flags().Uint64Var(&loadRate, "load-rate", 0, "bytes per second firmware images are read at, making boots of larger firmware take longer (0 leaves the size out)")
+	rootCmd.Flags().Uint64Var(&segmentLoadRate, "segment-load-rate", 0, "bytes per second ELF segments are copied to device memory at (requires --device-memory, 0 leaves the size out)")
+	rootCmd.Flags().DurationVar(&segmentLoadOverhead, "segment-load-overhead", 0, "fixed time copying each ELF segment to device memory takes (requires --device-memory)")
+	rootCmd.Flags().StringVar(&watcher, "watcher", "auto", "how sysfs writes are detected: inotify, poll or auto (inotify with polling fallback)")
+	rootCmd.Flags().DurationVar(&pollInterval, "poll-interval", 50*time.Millisecond, "how often the poll watcher scans for changes")
+	rootCmd.Flags().StringVar(&kernelRelease, "kernel-release", "6.12.0-remoteproc-simulator", "emulated kernel release, selects /lib/firmware/<release> and /lib/firmware/updates/<release>")
+	rootCmd.Flags().BoolVar(&firmwareFallback, "firmware-fallback", false, "let userspace supply missing firmware through /sys/class/firmware/<name>/")
+	rootCmd.Flags().DurationVar(&firmwareFallbackTimeout, "firmware-fallback-timeout", 60*time.Second, "initial content of /sys/class/firmware/timeout")
+	rootCmd.Flags().BoolVar(&charDevice, "cdev", false, "create /dev/remoteprocN, emulated with a Unix socket")
+	rootCmd.Flags().BoolVar(&deviceTree, "device-tree", false, "describe the remote processor in /sys/firmware/devicetree/base and /proc/device-tree")
+	rootCmd.Flags().StringVar(&compatible, "compatible", "sim,remoteproc", "compatible string of the device tree node")
+	rootCmd.Flags().StringArrayVar(&memoryRegions, "memory-region", nil, "reserved-memory region as name:address:size, can be repeated")
+	rootCmd.Flags().StringSliceVar(&mailboxes, "mbox", nil, "mailbox channel names (mbox-names) of the device tree node")
+	rootCmd.Flags().BoolVar(&deviceMemory, "device-memory", false, "load ELF firmware into carveouts backed by files in /run/remoteprocN/memory")
+	rootCmd.Flags().BoolVar(&vrings, "vrings", false, "lay out the vrings and buffer pool of each vdev of the resource table in device memory (requires --device-memory)")
+	rootCmd.Flags().BoolVar(&doorbell, "doorbell", false, "carry kicks and interrupts over /run/remoteprocN/doorbell, counted in debugfs")
+	rootCmd.Flags().DurationVar(&doorbellDelay, "doorbell-delay", 0, "delay injected in every doorbell notification")
+	rootCmd.Flags().Float64Var(&doorbellDropRate, "doorbell-drop-rate", 0, "probability, from 0 to 1, that a doorbell notification is lost")
+	rootCmd.Flags().DurationVar(&suspendLatency, "suspend-latency", 0, "how long suspending the remote processor takes")
+	rootCmd.Flags().DurationVar(&resumeLatency, "resume-latency", 0, "how long resuming the remote processor takes")
+	rootCmd.Flags().DurationVar(&autosuspendDelay, "autosuspend-delay", 0, "suspend the running remote processor after this long without kicks (0 disables autosuspend)")
+	rootCmd.Flags().DurationVar(&watchdogTimeout, "watchdog-timeout", 0, "crash the running remote processor with a watchdog reason when its watchdog is not fed for this long (0 disables the watchdog)")
+	rootCmd.Flags().Uint32Var(&heartbeatEndpoint, "heartbeat-endpoint", 0, "RPMsg address of heartbeat messages feeding the watchdog (requires --watchdog-timeout and --vrings)")
+	rootCmd.Flags().StringVar(&scenarioPath, "scenario", "", "JSON scenario file declaring the instances to simulate and their fault timelines, overriding --index and --name")
+	rootCmd.Flags().BoolVar(&chaos, "chaos", false, "inject random faults into every instance, at the --chaos-*-rate rates")
+	rootCmd.Flags().Uint64Var(&chaosSeed, "chaos-seed", 0, "seed making chaos reproducible (default random, and logged)")
+	rootCmd.Flags().Float64Var(&chaosCrashRate, "chaos-crash-rate", 1, "mean number of crashes per minute")
+	rootCmd.Flags().Float64Var(&chaosBootFailureRate, "chaos-boot-failure-rate", 1, "mean number of boot failures per minute")
+	rootCmd.Flags().Float64Var(&chaosSlowBootRate, "chaos-slow-boot-rate", 1, "mean number of slow boots per minute")
+	rootCmd.Flags().Float64Var(&chaosStopFailureRate, "chaos-stop-failure-rate", 1, "mean number of stop failures per minute")
+	rootCmd.Flags().DurationVar(&chaosSlowBootDelay, "chaos-slow-boot-delay", 5*time.Second, "how much longer a slow boot takes")
+	rootCmd.Flags().StringVar(&chaosLogPath, "chaos-log", "", "record injected faults in this file, a scenario replaying them")
+	rootCmd.Flags().StringVar(&stateFile, "state-file", "", "persist the state, firmware, recovery and coredump settings and counters of each instance in this file, and restore them on start up")
+	rootCmd.Flags().StringVar(&cleanup, "cleanup", "remove-created", "what to remove on exit: remove-created (directories the simulator created), remove-all (also existing instance directories) or keep")
+	rootCmd.Flags().BoolVar(&keepArtifactsOnFailure, "keep-artifacts-on-failure", false, "remove nothing on exit when an instance failed to start or is crashed, for CI debugging")
+	rootCmd.Flags().BoolVar(&adoptExisting, "adopt-existing", false, "reuse instance directories left by an earlier run instead of refusing to start")
+	rootCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 5*time.Second, "how long to wait on exit for each instance to stop before removing its files")
 	rootCmd.Flags().BoolVar(&showVersion, "version", false, "show version information")
 
 	if err := rootCmd.Execute(); err != nil {
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+)
+
+// reloadScenario brings the instances in line with the scenario file:
+// instances no longer declared, or renamed, are torn down in reverse order,
+// the others are reconfigured, and new ones are created. It returns the
+// instances in the order of the scenario.
+func reloadScenario(path string, base simulator.Config, sims []*simulator.Remoteproc, shutdownTimeout time.Duration) []*simulator.Remoteproc {
+	scenario, err := simulator.LoadScenario(path)
+	if err != nil {
+		log.Printf("Reload failed, keeping the current instances: %s", err)
+		return sims
+	}
+	log.Printf("Reloading %s", path)
+
+	names := map[uint]string{}
+	for _, instance := range scenario.Instances {
+		names[instance.Index] = instance.Name
+	}
+	current := map[uint]*simulator.Remoteproc{}
+	for _, sim := range slices.Backward(sims) {
+		if name, ok := names[sim.Index()]; ok && name == sim.Name() {
+			current[sim.Index()] = sim
+			continue
+		}
+		log.Printf("Removing %s", sim.Name())
+		if err := sim.Shutdown(shutdownTimeout); err != nil {
+			log.Printf("Shutdown failed: %s", err)
+		}
+		sim.Close()
+	}
+
+	var reloaded []*simulator.Remoteproc
+	for _, instance := range scenario.Instances {
+		config := instance.Config(base)
+		if sim, ok := current[config.Index]; ok {
+			if err := sim.Reconfigure(config); err != nil {
+				log.Printf("Failed to reconfigure %s: %s", sim.Name(), err)
+			}
+			reloaded = append(reloaded, sim)
+			continue
+		}
+		log.Printf("Adding %s", config.Name)
+		sim, err := simulator.NewRemoteproc(config)
+		if err != nil {
+			log.Printf("Failed to add %s: %s", config.Name, err)
+			continue
+		}
+		reloaded = append(reloaded, sim)
+	}
+	return reloaded
+}
+
+// dumpSnapshots logs a snapshot of every instance.
+func dumpSnapshots(sims []*simulator.Remoteproc) {
+	for _, sim := range sims {
+		snapshot, err := sim.Snapshot()
+		if err != nil {
+			log.Printf("Failed to snapshot %s: %s", sim.Name(), err)
+			continue
+		}
+		log.Printf("Snapshot %s", snapshot)
+	}
+}
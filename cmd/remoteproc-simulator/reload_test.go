@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadScenario(t *testing.T) {
+	t.Run("it removes an instance without disturbing those still running", func(t *testing.T) {
+		root := t.TempDir()
+		scenarioPath := filepath.Join(t.TempDir(), "scenario.json")
+		writeScenario := func(content string) {
+			require.NoError(t, os.WriteFile(scenarioPath, []byte(content), 0644))
+		}
+		writeScenario(`{"instances": [{"index": 0, "name": "dsp0"}, {"index": 1, "name": "dsp1", "firmware": "fw.elf", "autoBoot": true}]}`)
+		firmware := filepath.Join(root, "lib", "firmware", "fw.elf")
+		require.NoError(t, os.MkdirAll(filepath.Dir(firmware), 0755))
+		require.NoError(t, os.WriteFile(firmware, []byte("firmware"), 0644))
+
+		base := simulator.Config{RootDir: root, BootDelay: time.Millisecond}
+		scenario, err := simulator.LoadScenario(scenarioPath)
+		require.NoError(t, err)
+		var sims []*simulator.Remoteproc
+		for _, instance := range scenario.Instances {
+			sim, err := simulator.NewRemoteproc(instance.Config(base))
+			require.NoError(t, err)
+			sims = append(sims, sim)
+		}
+		t.Cleanup(func() {
+			for _, sim := range sims {
+				sim.Close()
+			}
+		})
+		require.Eventually(t, func() bool { return sims[1].State() == simulator.StateRunning }, time.Second, time.Millisecond)
+
+		writeScenario(`{"instances": [{"index": 1, "name": "dsp1", "firmware": "fw.elf", "autoBoot": true}]}`)
+		sims = reloadScenario(scenarioPath, base, sims, time.Second)
+
+		require.Len(t, sims, 1)
+		assert.Equal(t, simulator.StateRunning, sims[0].State())
+		assert.NoDirExists(t, filepath.Join(root, "sys", "class", "remoteproc", "remoteproc0"))
+		assert.FileExists(t, filepath.Join(sims[0].InstanceDir(), "state"))
+		assert.FileExists(t, firmware)
+		assert.DirExists(t, filepath.Join(root, "run"))
+	})
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignal re-reads the scenario file, and dumpSignal logs a snapshot of
+// every instance.
+var (
+	reloadSignal os.Signal = syscall.SIGHUP
+	dumpSignal   os.Signal = syscall.SIGUSR1
+)
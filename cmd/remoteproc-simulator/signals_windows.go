@@ -0,0 +1,12 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Windows has no SIGHUP nor SIGUSR1, so the scenario can't be reloaded and
+// snapshots can't be dumped.
+var (
+	reloadSignal os.Signal
+	dumpSignal   os.Signal
+)
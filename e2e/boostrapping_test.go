@@ -26,6 +26,26 @@ func TestBootstrapping(t *testing.T) {
 		assertFileContent(t, filepath.Join(instanceDir, "firmware"), "")
 	})
 
+	t.Run("default firmware can be configured", func(t *testing.T) {
+		root := t.TempDir()
+
+		runSimulator(t, "--root-dir", root, "--firmware", "some-firmware.elf")
+
+		instanceDir := filepath.Join(root, "sys", "class", "remoteproc", "remoteproc0")
+		assertFileContent(t, filepath.Join(instanceDir, "firmware"), "some-firmware.elf")
+		requireState(t, instanceDir, "offline")
+	})
+
+	t.Run("auto boot starts the default firmware", func(t *testing.T) {
+		root := t.TempDir()
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+
+		runSimulator(t, "--root-dir", root, "--firmware", "some-firmware.elf", "--auto-boot")
+
+		instanceDir := filepath.Join(root, "sys", "class", "remoteproc", "remoteproc0")
+		requireState(t, instanceDir, "running")
+	})
+
 	t.Run("instance name can be overriden", func(t *testing.T) {
 		root := t.TempDir()
 		name := "fancy-device"
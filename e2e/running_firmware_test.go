@@ -25,6 +25,18 @@ func TestRunningFirmware(t *testing.T) {
 		requireState(t, instanceDir, "offline")
 	})
 
+	t.Run("sysfs writes are detected by the polling watcher", func(t *testing.T) {
+		root := t.TempDir()
+		runSimulator(t, "--root-dir", root, "--watcher", "poll", "--poll-interval", "10ms")
+		instanceDir := filepath.Join(root, "sys", "class", "remoteproc", "remoteproc0")
+
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+		loadFirmware(t, instanceDir, "some-firmware.elf")
+		setRemoteprocState(t, instanceDir, "start")
+
+		requireState(t, instanceDir, "running")
+	})
+
 	t.Run("firmware file must exist in order to start remoteproc successfully", func(t *testing.T) {
 		t.Run("in default firmware directory - /lib/firmware", func(t *testing.T) {
 			root := t.TempDir()
@@ -53,6 +65,32 @@ func TestRunningFirmware(t *testing.T) {
 			requireState(t, instanceDir, "running")
 		})
 
+		t.Run("in firmware updates directory of the emulated kernel release - /lib/firmware/updates/<release>", func(t *testing.T) {
+			root := t.TempDir()
+			runSimulator(t, "--root-dir", root, "--index", "0", "--kernel-release", "6.1.0-test")
+			instanceDir := filepath.Join(root, "sys", "class", "remoteproc", "remoteproc0")
+			updatesDir := filepath.Join(root, "lib", "firmware", "updates", "6.1.0-test")
+
+			require.NoError(t, os.MkdirAll(updatesDir, 0755))
+			createFirmwareFile(t, filepath.Join(updatesDir, "some-firmware.elf"))
+			loadFirmware(t, instanceDir, "some-firmware.elf")
+			setRemoteprocState(t, instanceDir, "start")
+
+			requireState(t, instanceDir, "running")
+		})
+
+		t.Run("when compressed firmware file is corrupt, remoteproc fails to start", func(t *testing.T) {
+			root := t.TempDir()
+			runSimulator(t, "--root-dir", root, "--index", "0")
+			instanceDir := filepath.Join(root, "sys", "class", "remoteproc", "remoteproc0")
+
+			require.NoError(t, writeFile(filepath.Join(root, "lib", "firmware", "some-firmware.elf.xz"), "not xz"))
+			loadFirmware(t, instanceDir, "some-firmware.elf")
+			setRemoteprocState(t, instanceDir, "start")
+
+			requireState(t, instanceDir, "offline")
+		})
+
 		t.Run("when firmware file doesn't exist, remoteproc fails to start", func(t *testing.T) {
 			root := t.TempDir()
 			runSimulator(t, "--root-dir", root, "--index", "0")
@@ -79,6 +117,7 @@ func TestRunningFirmware(t *testing.T) {
 
 func createFirmwareFile(t *testing.T, pathToFirmwareFile string) {
 	firmwarePath := filepath.Join(pathToFirmwareFile)
+	require.NoError(t, os.MkdirAll(filepath.Dir(firmwarePath), 0755))
 	require.NoError(t, writeFile(firmwarePath, ""))
 }
 
@@ -1,13 +1,14 @@
 package dirwatcher
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
-
-	"github.com/fsnotify/fsnotify"
+	"time"
 )
 
 type FileChangeEvent struct {
@@ -15,77 +16,212 @@ type FileChangeEvent struct {
 	Value    string
 }
 
+type Backend string
+
+const (
+	// BackendAuto uses inotify and falls back to polling when it is unavailable
+	BackendAuto Backend = "auto"
+	// BackendInotify relies on filesystem notifications
+	BackendInotify Backend = "inotify"
+	// BackendPoll periodically scans the directory, for filesystems where
+	// notifications are not delivered, e.g. some overlay and network filesystems
+	BackendPoll Backend = "poll"
+)
+
+const (
+	defaultPollInterval = 50 * time.Millisecond
+	defaultSettleDelay  = 10 * time.Millisecond
+)
+
+type Config struct {
+	// Backend defaults to BackendAuto
+	Backend Backend
+	// PollInterval is how often BackendPoll scans the directory, defaults to 50ms
+	PollInterval time.Duration
+	// SettleDelay is how long a file must stay untouched before it is read,
+	// so that partial writes are coalesced into a single event. Defaults to 10ms.
+	SettleDelay time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.Backend == "" {
+		c.Backend = BackendAuto
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = defaultPollInterval
+	}
+	if c.SettleDelay == 0 {
+		c.SettleDelay = defaultSettleDelay
+	}
+	return c
+}
+
+// backend reports the names of files in the watched directory that may have
+// been created, written or renamed into place. It stops once its context is
+// cancelled or it is closed, and closes the Touched channel on its way out.
+type backend interface {
+	Touched() <-chan string
+	Close() error
+}
+
+// DirWatcher reports changes to the files of a single directory.
+//
+// Delivery never blocks the watcher: changes that the consumer has not yet
+// received are queued, and the queue holds at most one change per file. When a
+// file changes again before its previous change was received, the previous
+// value is dropped and the latest one is queued behind the other pending
+// changes. A slow consumer therefore always observes the latest content of
+// every file, in the order the files were last written, and the queue is
+// bounded by the number of files in the directory.
 type DirWatcher struct {
-	watcher      *fsnotify.Watcher
+	path         string
+	backend      backend
+	backendName  Backend
+	settleDelay  time.Duration
 	changeEvents chan FileChangeEvent
+	cancel       context.CancelFunc
+	done         chan struct{}
 }
 
-func New(path string) (*DirWatcher, error) {
-	watcher, err := setupFSNotify(path)
+// New starts watching path. The watcher stops when ctx is cancelled or Close
+// is called, after which the Changes channel is closed.
+func New(ctx context.Context, path string, config Config) (*DirWatcher, error) {
+	config = config.withDefaults()
+	ctx, cancel := context.WithCancel(ctx)
+	b, name, err := newBackend(ctx, path, config)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 	d := &DirWatcher{
-		watcher:      watcher,
+		path:         path,
+		backend:      b,
+		backendName:  name,
+		settleDelay:  config.SettleDelay,
 		changeEvents: make(chan FileChangeEvent),
+		cancel:       cancel,
+		done:         make(chan struct{}),
 	}
-	go d.loop()
+	go d.loop(ctx)
 	return d, nil
 }
 
+func newBackend(ctx context.Context, path string, config Config) (backend, Backend, error) {
+	switch config.Backend {
+	case BackendInotify:
+		b, err := newInotifyBackend(ctx, path)
+		return b, BackendInotify, err
+	case BackendPoll:
+		b, err := newPollBackend(ctx, path, config.PollInterval)
+		return b, BackendPoll, err
+	case BackendAuto:
+		b, err := newInotifyBackend(ctx, path)
+		if err == nil {
+			return b, BackendInotify, nil
+		}
+		log.Printf("inotify unavailable, falling back to polling: %v", err)
+		pb, err := newPollBackend(ctx, path, config.PollInterval)
+		return pb, BackendPoll, err
+	default:
+		return nil, "", fmt.Errorf("unknown watcher backend %q", config.Backend)
+	}
+}
+
 func (d *DirWatcher) Changes() <-chan FileChangeEvent {
 	return d.changeEvents
 }
 
+// Backend reports which backend is in use, which is only interesting when
+// BackendAuto was requested.
+func (d *DirWatcher) Backend() Backend {
+	return d.backendName
+}
+
+// Close stops the watcher and waits for all of its goroutines to exit. Pending
+// changes that were not received are discarded.
 func (d *DirWatcher) Close() error {
-	return d.watcher.Close()
+	d.cancel()
+	err := d.backend.Close()
+	<-d.done
+	return err
 }
 
-func (d *DirWatcher) loop() {
+// loop debounces notifications per file: a file is read only once it has not
+// been touched for the settle delay. This turns the truncate and write that
+// make up a single `echo value > file` into one event. Files are reported in
+// the order they were last touched, so writing firmware and then state is
+// seen in that order.
+func (d *DirWatcher) loop(ctx context.Context) {
+	defer close(d.done)
+	defer close(d.changeEvents)
+
+	touched := d.backend.Touched()
+	var pending []pendingFile
+	var queue []FileChangeEvent
+	timer := time.NewTimer(0)
+	<-timer.C
+	defer timer.Stop()
+
 	for {
+		var out chan<- FileChangeEvent
+		var next FileChangeEvent
+		if len(queue) > 0 {
+			out = d.changeEvents
+			next = queue[0]
+		}
+
 		select {
-		case event, ok := <-d.watcher.Events:
-			if !ok {
-				close(d.changeEvents)
-				return
+		case <-ctx.Done():
+			for range touched {
 			}
+			return
 
-			if event.Op&fsnotify.Write == 0 {
-				continue
+		case filename, ok := <-touched:
+			if !ok {
+				return
 			}
+			pending = slices.DeleteFunc(pending, func(p pendingFile) bool { return p.filename == filename })
+			pending = append(pending, pendingFile{filename: filename, deadline: time.Now().Add(d.settleDelay)})
+			timer.Reset(time.Until(pending[0].deadline))
 
-			filename := filepath.Base(event.Name)
-			content, err := os.ReadFile(event.Name)
-			if err != nil {
-				log.Printf("Error reading %s: %v", filename, err)
-				continue
+		case now := <-timer.C:
+			for len(pending) > 0 && !pending[0].deadline.After(now) {
+				filename := pending[0].filename
+				pending = pending[1:]
+				if event, ok := d.read(filename); ok {
+					queue = slices.DeleteFunc(queue, func(e FileChangeEvent) bool { return e.Filename == filename })
+					queue = append(queue, event)
+				}
 			}
-			value := strings.TrimSpace(string(content))
-			d.changeEvents <- FileChangeEvent{
-				Filename: filename,
-				Value:    value,
+			if len(pending) > 0 {
+				timer.Reset(time.Until(pending[0].deadline))
 			}
 
-		case err, ok := <-d.watcher.Errors:
-			if !ok {
-				close(d.changeEvents)
-				return
-			}
-			log.Printf("Watcher error: %v", err)
+		case out <- next:
+			queue = queue[1:]
 		}
 	}
 }
 
-func setupFSNotify(path string) (*fsnotify.Watcher, error) {
-	watcher, err := fsnotify.NewWatcher()
+type pendingFile struct {
+	filename string
+	deadline time.Time
+}
+
+func (d *DirWatcher) read(filename string) (FileChangeEvent, bool) {
+	content, err := os.ReadFile(filepath.Join(d.path, filename))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create file watcher: %v", err)
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading %s: %v", filename, err)
+		}
+		return FileChangeEvent{}, false
 	}
-
-	if err := watcher.Add(path); err != nil {
-		watcher.Close()
-		return nil, fmt.Errorf("failed to watch sysfs directory: %v", err)
+	value := strings.TrimSpace(string(content))
+	// An empty file is what a reader sees between the truncate and the write
+	// of a shell redirection. sysfs never delivers empty stores, so neither
+	// do we.
+	if value == "" {
+		return FileChangeEvent{}, false
 	}
-
-	return watcher, nil
+	return FileChangeEvent{Filename: filename, Value: value}, true
 }
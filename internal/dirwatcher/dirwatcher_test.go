@@ -0,0 +1,213 @@
+package dirwatcher_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/internal/dirwatcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var backends = []dirwatcher.Backend{dirwatcher.BackendInotify, dirwatcher.BackendPoll}
+
+func TestDirWatcher(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(string(backend), func(t *testing.T) {
+			t.Run("it reports writes to existing files", func(t *testing.T) {
+				dir := t.TempDir()
+				writeFile(t, filepath.Join(dir, "state"), "offline")
+				w := newWatcher(t, dir, backend)
+
+				writeFile(t, filepath.Join(dir, "state"), "start\n")
+
+				assert.Equal(t, []dirwatcher.FileChangeEvent{{Filename: "state", Value: "start"}}, collectEvents(w))
+			})
+
+			t.Run("it reports newly created files", func(t *testing.T) {
+				dir := t.TempDir()
+				w := newWatcher(t, dir, backend)
+
+				writeFile(t, filepath.Join(dir, "firmware"), "hello.elf")
+
+				assert.Equal(t, []dirwatcher.FileChangeEvent{{Filename: "firmware", Value: "hello.elf"}}, collectEvents(w))
+			})
+
+			t.Run("it reports files renamed into place", func(t *testing.T) {
+				dir := t.TempDir()
+				writeFile(t, filepath.Join(dir, "state"), "offline")
+				w := newWatcher(t, dir, backend)
+				tmp := filepath.Join(t.TempDir(), "state.tmp")
+				writeFile(t, tmp, "start")
+
+				require.NoError(t, os.Rename(tmp, filepath.Join(dir, "state")))
+
+				assert.Equal(t, []dirwatcher.FileChangeEvent{{Filename: "state", Value: "start"}}, collectEvents(w))
+			})
+
+			t.Run("it coalesces a truncate followed by a write into one event", func(t *testing.T) {
+				dir := t.TempDir()
+				writeFile(t, filepath.Join(dir, "state"), "offline")
+				w := newWatcher(t, dir, backend)
+
+				f, err := os.OpenFile(filepath.Join(dir, "state"), os.O_WRONLY|os.O_TRUNC, 0644)
+				require.NoError(t, err)
+				time.Sleep(2 * time.Millisecond)
+				_, err = f.WriteString("stop\n")
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+
+				assert.Equal(t, []dirwatcher.FileChangeEvent{{Filename: "state", Value: "stop"}}, collectEvents(w))
+			})
+
+			t.Run("it reports files in the order they were written", func(t *testing.T) {
+				dir := t.TempDir()
+				w := newWatcher(t, dir, backend)
+
+				writeFile(t, filepath.Join(dir, "firmware"), "hello.elf")
+				time.Sleep(5 * time.Millisecond)
+				writeFile(t, filepath.Join(dir, "state"), "start")
+
+				assert.Equal(t, []dirwatcher.FileChangeEvent{
+					{Filename: "firmware", Value: "hello.elf"},
+					{Filename: "state", Value: "start"},
+				}, collectEvents(w))
+			})
+
+			t.Run("it closes the change channel on close", func(t *testing.T) {
+				w, err := dirwatcher.New(context.Background(), t.TempDir(), dirwatcher.Config{Backend: backend})
+				require.NoError(t, err)
+
+				require.NoError(t, w.Close())
+
+				select {
+				case _, ok := <-w.Changes():
+					assert.False(t, ok)
+				case <-time.After(time.Second):
+					t.Fatal("change channel was not closed")
+				}
+			})
+		})
+	}
+
+	t.Run("it keeps only the latest value of each file for a slow consumer", func(t *testing.T) {
+		dir := t.TempDir()
+		w := newWatcher(t, dir, dirwatcher.BackendInotify)
+
+		for _, value := range []string{"start", "stop", "start"} {
+			writeFile(t, filepath.Join(dir, "state"), value)
+			time.Sleep(30 * time.Millisecond)
+		}
+		writeFile(t, filepath.Join(dir, "firmware"), "hello.elf")
+		time.Sleep(30 * time.Millisecond)
+		writeFile(t, filepath.Join(dir, "state"), "stop")
+		time.Sleep(30 * time.Millisecond)
+
+		assert.Equal(t, []dirwatcher.FileChangeEvent{
+			{Filename: "firmware", Value: "hello.elf"},
+			{Filename: "state", Value: "stop"},
+		}, collectEvents(w))
+	})
+
+	for _, backend := range backends {
+		t.Run(string(backend)+" shutdown", func(t *testing.T) {
+			t.Run("close does not block on an absent consumer", func(t *testing.T) {
+				dir := t.TempDir()
+				w, err := dirwatcher.New(context.Background(), dir, dirwatcher.Config{Backend: backend, PollInterval: 5 * time.Millisecond})
+				require.NoError(t, err)
+				writeFile(t, filepath.Join(dir, "state"), "start")
+				writeFile(t, filepath.Join(dir, "firmware"), "hello.elf")
+				time.Sleep(50 * time.Millisecond)
+
+				closed := make(chan error)
+				go func() { closed <- w.Close() }()
+
+				select {
+				case err := <-closed:
+					assert.NoError(t, err)
+				case <-time.After(time.Second):
+					t.Fatal("Close blocked")
+				}
+			})
+
+			t.Run("cancelling the context closes the change channel", func(t *testing.T) {
+				ctx, cancel := context.WithCancel(context.Background())
+				w, err := dirwatcher.New(ctx, t.TempDir(), dirwatcher.Config{Backend: backend})
+				require.NoError(t, err)
+				t.Cleanup(func() { w.Close() })
+
+				cancel()
+
+				require.Eventually(t, func() bool {
+					_, ok := <-w.Changes()
+					return !ok
+				}, time.Second, time.Millisecond)
+			})
+
+			t.Run("it leaks no goroutines", func(t *testing.T) {
+				before := runtime.NumGoroutine()
+				dir := t.TempDir()
+
+				for range 10 {
+					w, err := dirwatcher.New(context.Background(), dir, dirwatcher.Config{Backend: backend, PollInterval: 5 * time.Millisecond})
+					require.NoError(t, err)
+					writeFile(t, filepath.Join(dir, "state"), "start")
+					time.Sleep(20 * time.Millisecond)
+					require.NoError(t, w.Close())
+				}
+
+				// Not assert.Eventually, which runs its condition in a goroutine
+				// of its own.
+				deadline := time.Now().Add(time.Second)
+				for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+				assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+			})
+		})
+	}
+
+	t.Run("it rejects unknown backends", func(t *testing.T) {
+		_, err := dirwatcher.New(context.Background(), t.TempDir(), dirwatcher.Config{Backend: "carrier-pigeon"})
+
+		assert.ErrorContains(t, err, "unknown watcher backend")
+	})
+
+	t.Run("auto backend prefers inotify", func(t *testing.T) {
+		w := newWatcher(t, t.TempDir(), dirwatcher.BackendAuto)
+
+		assert.Equal(t, dirwatcher.BackendInotify, w.Backend())
+	})
+}
+
+func newWatcher(t *testing.T, dir string, backend dirwatcher.Backend) *dirwatcher.DirWatcher {
+	t.Helper()
+	w, err := dirwatcher.New(context.Background(), dir, dirwatcher.Config{Backend: backend, PollInterval: 5 * time.Millisecond})
+	require.NoError(t, err)
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// collectEvents gathers everything reported within a window that comfortably
+// covers the poll interval and settle delay.
+func collectEvents(w *dirwatcher.DirWatcher) []dirwatcher.FileChangeEvent {
+	var events []dirwatcher.FileChangeEvent
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case event := <-w.Changes():
+			events = append(events, event)
+		case <-timeout:
+			return events
+		}
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
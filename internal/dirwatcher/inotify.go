@@ -0,0 +1,73 @@
+package dirwatcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+type inotifyBackend struct {
+	watcher *fsnotify.Watcher
+	touched chan string
+}
+
+func newInotifyBackend(ctx context.Context, path string) (*inotifyBackend, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %v", err)
+	}
+
+	if err := watcher.Add(path); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch sysfs directory: %v", err)
+	}
+
+	b := &inotifyBackend{
+		watcher: watcher,
+		touched: make(chan string),
+	}
+	go b.loop(ctx)
+	return b, nil
+}
+
+func (b *inotifyBackend) Touched() <-chan string {
+	return b.touched
+}
+
+func (b *inotifyBackend) Close() error {
+	return b.watcher.Close()
+}
+
+func (b *inotifyBackend) loop(ctx context.Context) {
+	defer close(b.touched)
+	defer b.watcher.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-b.watcher.Events:
+			if !ok {
+				return
+			}
+			// Renames are reported as a Create of the destination, which
+			// covers `mv tmp state`.
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			select {
+			case b.touched <- filepath.Base(event.Name):
+			case <-ctx.Done():
+				return
+			}
+
+		case err, ok := <-b.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watcher error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package dirwatcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type pollBackend struct {
+	path     string
+	interval time.Duration
+	seen     map[string]os.FileInfo
+	touched  chan string
+}
+
+func newPollBackend(ctx context.Context, path string, interval time.Duration) (*pollBackend, error) {
+	b := &pollBackend{
+		path:     path,
+		interval: interval,
+		touched:  make(chan string),
+	}
+	seen, err := b.scan()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan sysfs directory: %v", err)
+	}
+	b.seen = seen
+	go b.loop(ctx)
+	return b, nil
+}
+
+func (b *pollBackend) Touched() <-chan string {
+	return b.touched
+}
+
+// Close is a no-op, the backend stops when its context is cancelled.
+func (b *pollBackend) Close() error {
+	return nil
+}
+
+func (b *pollBackend) loop(ctx context.Context) {
+	defer close(b.touched)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current, err := b.scan()
+		if err != nil {
+			continue
+		}
+		for _, filename := range touchedInWriteOrder(b.seen, current) {
+			select {
+			case b.touched <- filename:
+			case <-ctx.Done():
+				return
+			}
+		}
+		b.seen = current
+	}
+}
+
+func (b *pollBackend) scan() (map[string]os.FileInfo, error) {
+	entries, err := os.ReadDir(b.path)
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[string]os.FileInfo, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos[entry.Name()] = info
+	}
+	return infos, nil
+}
+
+// changed reports whether a file was written or replaced between two scans.
+// Comparing identity catches renames over a file that keep size and mtime.
+func changed(previous, current os.FileInfo) bool {
+	return !os.SameFile(previous, current) ||
+		!previous.ModTime().Equal(current.ModTime()) ||
+		previous.Size() != current.Size()
+}
+
+func touchedInWriteOrder(previous, current map[string]os.FileInfo) []string {
+	var touched []string
+	for filename, info := range current {
+		if before, ok := previous[filename]; ok && !changed(before, info) {
+			continue
+		}
+		touched = append(touched, filename)
+	}
+	slices.SortFunc(touched, func(a, b string) int {
+		if c := current[a].ModTime().Compare(current[b].ModTime()); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	return touched
+}
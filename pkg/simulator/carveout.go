@@ -0,0 +1,332 @@
+package simulator
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// dmaPoolBase is where carveouts the firmware lets the host place are
+	// allocated from.
+	dmaPoolBase = 0x8000_0000
+	pageSize    = 0x1000
+)
+
+// Carveout is device memory of the remote processor, backed by a file that
+// can be read or mapped to inspect it.
+type Carveout struct {
+	Name string
+	// DeviceAddress is where the remote processor sees the carveout
+	DeviceAddress uint64
+	Size          uint64
+	// Path is the file backing the carveout
+	Path string
+}
+
+type carveout struct {
+	Carveout
+	file *os.File
+	// registered tells whether the carveout comes from a memory region
+	// rather than from the resource table
+	registered bool
+	// rscOffset is the offset of the carveout resource associated with the
+	// carveout, or -1
+	rscOffset int64
+}
+
+func (c *carveout) contains(da, length uint64) bool {
+	return da >= c.DeviceAddress && da-c.DeviceAddress+length <= c.Size && da-c.DeviceAddress+length >= length
+}
+
+// Carveouts returns the device memory allocated for the firmware that is
+// running or booting, in the order the kernel looks addresses up.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) Carveouts() []Carveout {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	carveouts := make([]Carveout, 0, len(r.carveouts))
+	for _, c := range r.carveouts {
+		carveouts = append(carveouts, c.Carveout)
+	}
+	return carveouts
+}
+
+// loadELF loads firmware like rproc_fw_boot does with the ELF loader: it
+// handles the resource table, allocates carveouts, then copies the PT_LOAD
+// segments to the device addresses given by their physical address. It
+// returns how long copying the segments takes.
+func (r *Remoteproc) loadELF(image firmwareImage) (time.Duration, error) {
+	r.releaseCarveouts()
+
+	f, err := parseFirmwareELF(image.data)
+	if err != nil {
+		return 0, err
+	}
+	table, err := findResourceTable(f, image.data)
+	if err != nil {
+		return 0, err
+	}
+	if table == nil {
+		log.Printf("No resource table found for firmware %s", image.name)
+	}
+
+	for _, region := range r.memoryRegions {
+		r.addCarveout(&carveout{
+			Carveout:   Carveout{Name: region.Name, DeviceAddress: region.Address, Size: region.Size},
+			registered: true,
+			rscOffset:  -1,
+		})
+	}
+	if table != nil {
+		if err := r.handleResources(table); err != nil {
+			r.releaseCarveouts()
+			return 0, err
+		}
+	}
+	if err := r.allocateCarveouts(table); err != nil {
+		r.releaseCarveouts()
+		return 0, err
+	}
+	load, err := r.loadSegments(f, image.data)
+	if err != nil {
+		r.releaseCarveouts()
+		return 0, err
+	}
+	if table != nil {
+		r.publishVdevs(table, r.copyLoadedResourceTable(table))
+	}
+	return load, nil
+}
+
+// handleResources walks the resource table like rproc_handle_resources.
+func (r *Remoteproc) handleResources(table *resourceTable) error {
+	for i := range table.num() {
+		offset := table.entryOffset(i)
+		avail := len(table.data) - int(offset) - rscHeaderSize
+		if avail < 0 {
+			return fmt.Errorf("rsc table is truncated: %w", syscall.EINVAL)
+		}
+		switch rscType := table.u32(offset); {
+		case rscType >= rscVendorStart && rscType <= rscVendorEnd:
+			log.Printf("Ignoring vendor resource %d", rscType)
+		case rscType >= rscLast:
+			log.Printf("Unsupported resource %d", rscType)
+		case rscType == rscCarveout:
+			if err := r.handleCarveout(table, offset+rscHeaderSize, avail); err != nil {
+				return err
+			}
+		case rscType == rscVdev && r.vrings:
+			if err := r.handleVdev(table, offset+rscHeaderSize, avail); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Remoteproc) handleCarveout(table *resourceTable, offset uint32, avail int) error {
+	rsc, err := table.carveout(offset, avail)
+	if err != nil {
+		return err
+	}
+
+	if c := r.registeredCarveout(rsc.name); c != nil {
+		if c.rscOffset != -1 {
+			return fmt.Errorf("Carveout already associated to resource table: %w", syscall.ENOMEM)
+		}
+		if err := checkCarveout(c, rsc.da, rsc.len); err != nil {
+			return err
+		}
+		c.rscOffset = int64(offset)
+		return nil
+	}
+
+	da := uint64(rsc.da)
+	if rsc.da == fwRscAddrAny {
+		da = r.allocateDeviceAddress(uint64(rsc.len))
+	}
+	r.addCarveout(&carveout{
+		Carveout:  Carveout{Name: rsc.name, DeviceAddress: da, Size: uint64(rsc.len)},
+		rscOffset: int64(offset),
+	})
+	return nil
+}
+
+// registeredCarveout returns the carveout of the memory region called name,
+// if any.
+func (r *Remoteproc) registeredCarveout(name string) *carveout {
+	for _, c := range r.carveouts {
+		if c.registered && c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// checkCarveout is rproc_check_carveout: a resource using a memory region
+// must fit in it.
+func checkCarveout(c *carveout, da, length uint32) error {
+	if uint64(length) > c.Size {
+		return fmt.Errorf("Registered carveout doesn't fit len request: %w", syscall.ENOMEM)
+	}
+	if da == fwRscAddrAny {
+		return nil
+	}
+	if uint64(da) < c.DeviceAddress {
+		return fmt.Errorf("Registered carveout doesn't fit da request: %w", syscall.ENOMEM)
+	}
+	if uint64(da)-c.DeviceAddress+uint64(length) > c.Size {
+		return fmt.Errorf("Registered carveout doesn't fit len request: %w", syscall.ENOMEM)
+	}
+	return nil
+}
+
+// allocateDeviceAddress picks a page aligned address for a carveout of the
+// given size that overlaps no other carveout, standing in for the DMA
+// allocator.
+func (r *Remoteproc) allocateDeviceAddress(size uint64) uint64 {
+	da := uint64(dmaPoolBase)
+	for {
+		overlapping := false
+		for _, c := range r.carveouts {
+			if da < c.DeviceAddress+c.Size && c.DeviceAddress < da+size {
+				da = (c.DeviceAddress + c.Size + pageSize - 1) &^ (pageSize - 1)
+				overlapping = true
+			}
+		}
+		if !overlapping {
+			return da
+		}
+	}
+}
+
+func (r *Remoteproc) addCarveout(c *carveout) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.carveouts = append(r.carveouts, c)
+}
+
+// allocateCarveouts creates the backing files of all carveouts, zero-filled,
+// and writes the addresses picked back to the resource table, like
+// rproc_alloc_registered_carveouts.
+func (r *Remoteproc) allocateCarveouts(table *resourceTable) error {
+	for _, c := range r.carveouts {
+		file, path, err := r.fs.CreateCarveoutFile(c.Name, c.DeviceAddress, c.Size)
+		if err != nil {
+			return fmt.Errorf("failed to allocate carveout %s: %w", c.Name, err)
+		}
+		c.file = file
+		r.mu.Lock()
+		c.Path = path
+		r.mu.Unlock()
+		log.Printf("Carveout %s of %#x bytes at device address %#x backed by %s", c.Name, c.Size, c.DeviceAddress, path)
+
+		if table != nil && c.rscOffset != -1 {
+			offset := uint32(c.rscOffset)
+			if table.u32(offset) == fwRscAddrAny {
+				table.putU32(offset, uint32(c.DeviceAddress))
+			}
+			table.putU32(offset+4, table.u32(offset))
+		}
+	}
+	return nil
+}
+
+// daToVa finds the carveout holding length bytes at device address da, like
+// rproc_da_to_va.
+func (r *Remoteproc) daToVa(da, length uint64) (*carveout, uint64, bool) {
+	for _, c := range r.carveouts {
+		if c.file != nil && c.contains(da, length) {
+			return c, da - c.DeviceAddress, true
+		}
+	}
+	return nil, 0, false
+}
+
+// loadSegments is rproc_elf_load_segments, and returns how long the copies
+// take.
+func (r *Remoteproc) loadSegments(f *elf.File, data []byte) (time.Duration, error) {
+	var load time.Duration
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Memsz == 0 {
+			continue
+		}
+		da, memsz, filesz, offset := prog.Paddr, prog.Memsz, prog.Filesz, prog.Off
+		if filesz > memsz {
+			return 0, fmt.Errorf("bad phdr filesz 0x%x memsz 0x%x: %w", filesz, memsz, syscall.EINVAL)
+		}
+		if offset+filesz > uint64(len(data)) || offset+filesz < filesz {
+			return 0, fmt.Errorf("truncated fw: need 0x%x avail 0x%x: %w", offset+filesz, len(data), syscall.EINVAL)
+		}
+		c, carveoutOffset, ok := r.daToVa(da, memsz)
+		if !ok {
+			return 0, fmt.Errorf("bad phdr da 0x%x mem 0x%x: %w", da, memsz, syscall.EINVAL)
+		}
+		if _, err := c.file.WriteAt(data[offset:offset+filesz], int64(carveoutOffset)); err != nil {
+			return 0, fmt.Errorf("failed to load segment at 0x%x: %w", da, err)
+		}
+		if err := zeroFill(c.file, int64(carveoutOffset+filesz), memsz-filesz); err != nil {
+			return 0, fmt.Errorf("failed to clear segment at 0x%x: %w", da, err)
+		}
+		if segment := r.loadTime.segment(memsz); segment > 0 {
+			log.Printf("Copying segment at 0x%x (0x%x bytes) takes %s", da, memsz, segment)
+			load += segment
+		}
+	}
+	return load, nil
+}
+
+// zeroFill clears n bytes at offset, as segments may overlap what an earlier
+// one loaded.
+func zeroFill(file *os.File, offset int64, n uint64) error {
+	zeros := make([]byte, min(n, 64*1024))
+	for n > 0 {
+		chunk := min(n, uint64(len(zeros)))
+		if _, err := file.WriteAt(zeros[:chunk], offset); err != nil {
+			return err
+		}
+		offset += int64(chunk)
+		n -= chunk
+	}
+	return nil
+}
+
+// copyLoadedResourceTable updates the resource table the firmware finds in
+// device memory with the addresses the host picked, and tells whether the
+// table is loaded in a carveout.
+func (r *Remoteproc) copyLoadedResourceTable(table *resourceTable) bool {
+	c, offset, ok := r.daToVa(table.addr, uint64(len(table.data)))
+	if !ok {
+		return false
+	}
+	if _, err := c.file.WriteAt(table.data, int64(offset)); err != nil {
+		log.Printf("Failed to update loaded resource table: %s", err)
+	}
+	return true
+}
+
+// releaseCarveouts frees device memory, like rproc_resource_cleanup.
+func (r *Remoteproc) releaseCarveouts() {
+	var errs []error
+	for _, c := range r.carveouts {
+		if c.file == nil {
+			continue
+		}
+		errs = append(errs, c.file.Close(), os.Remove(c.Path))
+	}
+	if err := errors.Join(errs...); err != nil {
+		log.Printf("Failed to release carveouts: %s", err)
+	}
+	r.removeVdevDevices(r.vdevs)
+	r.mu.Lock()
+	r.carveouts = nil
+	r.vdevs = nil
+	r.mu.Unlock()
+	r.loadingVdevs = nil
+	r.nextNotifyID = 0
+}
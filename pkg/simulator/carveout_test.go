@@ -0,0 +1,231 @@
+package simulator_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceMemory(t *testing.T) {
+	codeConfig := simulator.Config{
+		DeviceMemory:  true,
+		MemoryRegions: []simulator.MemoryRegion{{Name: "dsp-code", Address: 0x1000_0000, Size: 0x10000}},
+	}
+
+	t.Run("it loads segments at their device address in a memory region", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, codeConfig)
+		firmware := testELF{segments: []testSegment{
+			{da: 0x1000_0100, data: []byte("text")},
+			{da: 0x1000_0200, data: []byte("data"), memsz: 8},
+		}}
+
+		require.NoError(t, bootFirmware(t, r, root, firmware.build()))
+		requireState(t, r, simulator.StateRunning)
+
+		carveouts := r.Carveouts()
+		require.Len(t, carveouts, 1)
+		assert.Equal(t, "dsp-code", carveouts[0].Name)
+		assert.Equal(t, filepath.Join(root, "run", "remoteproc0", "memory", "dsp-code@10000000"), carveouts[0].Path)
+		memory, err := os.ReadFile(carveouts[0].Path)
+		require.NoError(t, err)
+		assert.Len(t, memory, 0x10000)
+		assert.Equal(t, []byte("text"), memory[0x100:0x104])
+		assert.Equal(t, []byte("data\x00\x00\x00\x00"), memory[0x200:0x208])
+	})
+
+	t.Run("it allocates carveouts of the resource table", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{DeviceMemory: true})
+		table := testResourceTable(
+			testCarveoutRsc(0x2000_0000, 0x4000, "fixed"),
+			testCarveoutRsc(0xffffffff, 0x2000, "any"),
+		)
+		firmware := testELF{
+			segments: []testSegment{
+				{da: 0x2000_0000, data: []byte("code")},
+				{da: 0x2000_1000, data: table},
+			},
+			resourceTable:     table,
+			resourceTableAddr: 0x2000_1000,
+		}
+
+		require.NoError(t, bootFirmware(t, r, root, firmware.build()))
+
+		carveouts := r.Carveouts()
+		require.Len(t, carveouts, 2)
+		assert.Equal(t, simulator.Carveout{Name: "fixed", DeviceAddress: 0x2000_0000, Size: 0x4000, Path: filepath.Join(root, "run", "remoteproc0", "memory", "fixed@20000000")}, carveouts[0])
+		assert.Equal(t, simulator.Carveout{Name: "any", DeviceAddress: 0x8000_0000, Size: 0x2000, Path: filepath.Join(root, "run", "remoteproc0", "memory", "any@80000000")}, carveouts[1])
+
+		memory, err := os.ReadFile(carveouts[0].Path)
+		require.NoError(t, err)
+		loaded := memory[0x1000 : 0x1000+len(table)]
+		secondRsc := binary.LittleEndian.Uint32(loaded[20:]) + 4
+		assert.Equal(t, uint32(0x8000_0000), binary.LittleEndian.Uint32(loaded[secondRsc:]), "da")
+		assert.Equal(t, uint32(0x8000_0000), binary.LittleEndian.Uint32(loaded[secondRsc+4:]), "pa")
+	})
+
+	t.Run("it associates carveout resources with memory regions of the same name", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, codeConfig)
+		table := testResourceTable(testCarveoutRsc(0x1000_8000, 0x8000, "dsp-code"))
+		firmware := testELF{segments: []testSegment{{da: 0x1000_8000, data: []byte("code")}}, resourceTable: table}
+
+		require.NoError(t, bootFirmware(t, r, root, firmware.build()))
+
+		assert.Len(t, r.Carveouts(), 1)
+	})
+
+	t.Run("it refuses carveout resources that do not fit their memory region", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, codeConfig)
+		table := testResourceTable(testCarveoutRsc(0x1000_8000, 0x10000, "dsp-code"))
+		firmware := testELF{segments: []testSegment{{da: 0x1000_0000, data: []byte("code")}}, resourceTable: table}
+
+		err := bootFirmware(t, r, root, firmware.build())
+
+		assert.ErrorContains(t, err, "Registered carveout doesn't fit len request")
+		assert.ErrorIs(t, err, syscall.ENOMEM)
+		assert.Equal(t, simulator.StateOffline, r.State())
+	})
+
+	t.Run("it fails when a segment falls outside every carveout", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, codeConfig)
+		firmware := testELF{segments: []testSegment{{da: 0x1000_fff0, data: []byte("code"), memsz: 0x20}}}
+
+		err := bootFirmware(t, r, root, firmware.build())
+
+		assert.ErrorContains(t, err, "bad phdr da 0x1000fff0 mem 0x20")
+		assert.ErrorIs(t, err, syscall.EINVAL)
+		assert.Equal(t, simulator.StateOffline, r.State())
+		assert.Empty(t, r.Carveouts())
+		assert.NoFileExists(t, filepath.Join(root, "run", "remoteproc0", "memory", "dsp-code@10000000"))
+	})
+
+	t.Run("it refuses firmware that is not an ELF image", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, codeConfig)
+
+		err := bootFirmware(t, r, root, bytes.Repeat([]byte("x"), 64))
+
+		assert.ErrorContains(t, err, "Image is corrupted (bad magic)")
+		assert.Equal(t, simulator.StateOffline, r.State())
+	})
+
+	t.Run("it refuses resource tables of unsupported versions", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, codeConfig)
+		table := testResourceTable()
+		table[0] = 2
+		firmware := testELF{segments: []testSegment{{da: 0x1000_0000, data: []byte("code")}}, resourceTable: table}
+
+		err := bootFirmware(t, r, root, firmware.build())
+
+		assert.ErrorContains(t, err, "unsupported fw ver: 2")
+	})
+
+	t.Run("it frees carveouts when the remote processor stops", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, codeConfig)
+		firmware := testELF{segments: []testSegment{{da: 0x1000_0000, data: []byte("code")}}}
+		require.NoError(t, bootFirmware(t, r, root, firmware.build()))
+		requireState(t, r, simulator.StateRunning)
+		path := r.Carveouts()[0].Path
+
+		require.NoError(t, r.Stop())
+
+		assert.Empty(t, r.Carveouts())
+		assert.NoFileExists(t, path)
+	})
+}
+
+type testSegment struct {
+	da   uint32
+	data []byte
+	// memsz defaults to the size of data
+	memsz uint32
+}
+
+// testELF builds a little-endian ELF32 firmware image.
+type testELF struct {
+	segments          []testSegment
+	resourceTable     []byte
+	resourceTableAddr uint32
+}
+
+func (e testELF) build() []byte {
+	const ehdrSize, phdrSize, shdrSize = 52, 32, 40
+	le := binary.LittleEndian
+
+	var body bytes.Buffer
+	dataStart := ehdrSize + phdrSize*len(e.segments)
+	var phdrs []byte
+	for _, s := range e.segments {
+		memsz := s.memsz
+		if memsz == 0 {
+			memsz = uint32(len(s.data))
+		}
+		for _, v := range []uint32{1, uint32(dataStart + body.Len()), s.da, s.da, uint32(len(s.data)), memsz, 7, 4} {
+			phdrs = le.AppendUint32(phdrs, v)
+		}
+		body.Write(s.data)
+	}
+
+	shstrtab := []byte("\x00.resource_table\x00.shstrtab\x00")
+	sections := [][]uint32{make([]uint32, 10)}
+	if e.resourceTable != nil {
+		sections = append(sections, []uint32{1, 1, 2, e.resourceTableAddr, uint32(dataStart + body.Len()), uint32(len(e.resourceTable)), 0, 0, 4, 0})
+		body.Write(e.resourceTable)
+	}
+	sections = append(sections, []uint32{17, 3, 0, 0, uint32(dataStart + body.Len()), uint32(len(shstrtab)), 0, 0, 1, 0})
+	body.Write(shstrtab)
+	shoff := dataStart + body.Len()
+
+	ehdr := []byte{0x7f, 'E', 'L', 'F', 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	ehdr = le.AppendUint16(ehdr, 2)  // e_type: ET_EXEC
+	ehdr = le.AppendUint16(ehdr, 40) // e_machine: EM_ARM
+	ehdr = le.AppendUint32(ehdr, 1)
+	ehdr = le.AppendUint32(ehdr, 0)
+	ehdr = le.AppendUint32(ehdr, ehdrSize)
+	ehdr = le.AppendUint32(ehdr, uint32(shoff))
+	ehdr = le.AppendUint32(ehdr, 0)
+	for _, v := range []int{ehdrSize, phdrSize, len(e.segments), shdrSize, len(sections), len(sections) - 1} {
+		ehdr = le.AppendUint16(ehdr, uint16(v))
+	}
+
+	image := append(ehdr, phdrs...)
+	image = append(image, body.Bytes()...)
+	for _, section := range sections {
+		for _, v := range section {
+			image = le.AppendUint32(image, v)
+		}
+	}
+	return image
+}
+
+func testResourceTable(entries ...[]byte) []byte {
+	le := binary.LittleEndian
+	table := le.AppendUint32(nil, 1)
+	table = le.AppendUint32(table, uint32(len(entries)))
+	table = append(table, make([]byte, 8)...)
+	offset := len(table) + 4*len(entries)
+	for _, entry := range entries {
+		table = le.AppendUint32(table, uint32(offset))
+		offset += len(entry)
+	}
+	for _, entry := range entries {
+		table = append(table, entry...)
+	}
+	return table
+}
+
+func testCarveoutRsc(da, length uint32, name string) []byte {
+	le := binary.LittleEndian
+	rsc := le.AppendUint32(nil, 0) // RSC_CARVEOUT
+	for _, v := range []uint32{da, 0, length, 0, 0} {
+		rsc = le.AppendUint32(rsc, v)
+	}
+	nameField := make([]byte, 32)
+	copy(nameField, name)
+	return append(rsc, nameField...)
+}
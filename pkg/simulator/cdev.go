@@ -0,0 +1,221 @@
+package simulator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// cdevMajor is the major number reported for /dev/remoteprocN. The kernel
+// allocates it dynamically, so any number from the dynamic range will do.
+const cdevMajor = 250
+
+const (
+	ioctlSetShutdownOnRelease = "RPROC_SET_SHUTDOWN_ON_RELEASE"
+	ioctlGetShutdownOnRelease = "RPROC_GET_SHUTDOWN_ON_RELEASE"
+)
+
+// cdev emulates the remoteproc character device with a Unix socket. Every
+// connection stands for an open file descriptor and speaks a line based
+// protocol, one request per line:
+//
+//	start
+//	stop
+//	ioctl RPROC_SET_SHUTDOWN_ON_RELEASE <0|1>
+//	ioctl RPROC_GET_SHUTDOWN_ON_RELEASE
+//
+// Each request is answered with "OK", followed by a value for
+// RPROC_GET_SHUTDOWN_ON_RELEASE, or "ERR <errno> <message>".
+type cdev struct {
+	path     string
+	listener net.Listener
+	r        *Remoteproc
+
+	mu    sync.Mutex
+	conns map[*cdevConn]struct{}
+	wg    sync.WaitGroup
+}
+
+// cdevConn is an open file descriptor of the character device. Its fields
+// are owned by the remoteproc loop.
+type cdevConn struct {
+	conn              net.Conn
+	shutdownOnRelease bool
+}
+
+func newCdev(path string, r *Remoteproc) (*cdev, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale character device %s: %w", path, err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create character device %s: %w", path, err)
+	}
+	c := &cdev{
+		path:     path,
+		listener: listener,
+		r:        r,
+		conns:    map[*cdevConn]struct{}{},
+	}
+	c.wg.Add(1)
+	go c.accept()
+	return c, nil
+}
+
+// Close removes the character device and waits for open connections to be
+// dropped. It must be called once the remoteproc loop has stopped, so that
+// closing them does not release the remote processor.
+func (c *cdev) Close() error {
+	err := c.listener.Close()
+	c.mu.Lock()
+	for conn := range c.conns {
+		conn.conn.Close()
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+	return err
+}
+
+func (c *cdev) accept() {
+	defer c.wg.Done()
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			return
+		}
+		cc := &cdevConn{conn: conn}
+		c.mu.Lock()
+		c.conns[cc] = struct{}{}
+		c.mu.Unlock()
+		c.wg.Add(1)
+		go c.serve(cc)
+	}
+}
+
+func (c *cdev) serve(cc *cdevConn) {
+	defer c.wg.Done()
+	defer func() {
+		c.mu.Lock()
+		delete(c.conns, cc)
+		c.mu.Unlock()
+		cc.conn.Close()
+		c.r.send(func() error { return c.r.releaseCdev(cc) })
+	}()
+
+	scanner := bufio.NewScanner(cc.conn)
+	for scanner.Scan() {
+		request := strings.TrimSpace(scanner.Text())
+		if request == "" {
+			continue
+		}
+		var value string
+		err := c.r.send(func() error {
+			var err error
+			value, err = c.r.handleCdevRequest(cc, request)
+			return err
+		})
+		if _, werr := fmt.Fprintln(cc.conn, cdevResponse(value, err)); werr != nil {
+			return
+		}
+	}
+}
+
+func cdevResponse(value string, err error) string {
+	if err == nil {
+		return strings.TrimSpace("OK " + value)
+	}
+	errno := "EIO"
+	var e syscall.Errno
+	if errors.As(err, &e) {
+		errno = errnoName(e)
+	}
+	return fmt.Sprintf("ERR %s %s", errno, err)
+}
+
+func errnoName(e syscall.Errno) string {
+	switch e {
+	case syscall.EINVAL:
+		return "EINVAL"
+	case syscall.EBUSY:
+		return "EBUSY"
+	case syscall.ENOTTY:
+		return "ENOTTY"
+	default:
+		return "EIO"
+	}
+}
+
+// handleCdevRequest emulates rproc_cdev_write and rproc_device_ioctl.
+func (r *Remoteproc) handleCdevRequest(cc *cdevConn, request string) (string, error) {
+	fields := strings.Fields(request)
+	switch fields[0] {
+	case "start":
+		switch r.state {
+		case StateOffline:
+		case StateRunning, StateAttached:
+			return "", fmt.Errorf("cannot start remoteproc in state %s: %w", r.state, syscall.EBUSY)
+		default:
+			return "", fmt.Errorf("cannot start remoteproc in state %s: %w", r.state, syscall.EINVAL)
+		}
+		if err := r.boot(); err != nil {
+			return "", err
+		}
+		r.cdevOwner = cc
+		return "", nil
+	case "stop":
+		if !r.state.isUp() {
+			return "", fmt.Errorf("cannot stop remoteproc in state %s: %w", r.state, syscall.EINVAL)
+		}
+		return "", r.shutdown()
+	case "ioctl":
+		return r.handleCdevIoctl(cc, fields[1:])
+	default:
+		return "", fmt.Errorf("unrecognized option %s: %w", fields[0], syscall.EINVAL)
+	}
+}
+
+func (r *Remoteproc) handleCdevIoctl(cc *cdevConn, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("missing ioctl request: %w", syscall.EINVAL)
+	}
+	switch args[0] {
+	case ioctlSetShutdownOnRelease:
+		if len(args) != 2 {
+			return "", fmt.Errorf("%s takes one argument: %w", args[0], syscall.EINVAL)
+		}
+		param, err := strconv.ParseInt(args[1], 0, 32)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s argument %s: %w", args[0], args[1], syscall.EINVAL)
+		}
+		cc.shutdownOnRelease = param != 0
+		return "", nil
+	case ioctlGetShutdownOnRelease:
+		if cc.shutdownOnRelease {
+			return "1", nil
+		}
+		return "0", nil
+	default:
+		return "", fmt.Errorf("unknown ioctl %s: %w", args[0], syscall.ENOTTY)
+	}
+}
+
+// releaseCdev emulates rproc_cdev_release: the remote processor is shut down
+// when the file descriptor that booted it, and asked to, is closed.
+func (r *Remoteproc) releaseCdev(cc *cdevConn) error {
+	if r.cdevOwner != cc {
+		return nil
+	}
+	r.cdevOwner = nil
+	if !cc.shutdownOnRelease || !r.state.isUp() {
+		return nil
+	}
+	log.Printf("Character device released, shutting down remoteproc")
+	return r.shutdown()
+}
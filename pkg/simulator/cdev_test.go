@@ -0,0 +1,140 @@
+package simulator_test
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCharacterDevice(t *testing.T) {
+	newCdevRemoteproc := func(t *testing.T) (*simulator.Remoteproc, string) {
+		root := t.TempDir()
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{RootDir: root, Name: "dsp0", BootDelay: 10 * time.Millisecond, CharDevice: true})
+		return r, filepath.Join(root, "dev", "remoteproc0")
+	}
+
+	t.Run("writing start and stop boots and shuts down the remote processor", func(t *testing.T) {
+		r, dev := newCdevRemoteproc(t)
+		fd := openCdev(t, dev)
+
+		assert.Equal(t, "OK", fd.request(t, "start"))
+		requireState(t, r, simulator.StateRunning)
+		assert.Equal(t, "OK", fd.request(t, "stop"))
+		requireState(t, r, simulator.StateOffline)
+	})
+
+	t.Run("start is refused with EBUSY while running", func(t *testing.T) {
+		r, dev := newCdevRemoteproc(t)
+		fd := openCdev(t, dev)
+		require.NoError(t, r.Start())
+		requireState(t, r, simulator.StateRunning)
+
+		assert.True(t, strings.HasPrefix(fd.request(t, "start"), "ERR EBUSY "))
+	})
+
+	t.Run("unrecognized requests are refused", func(t *testing.T) {
+		_, dev := newCdevRemoteproc(t)
+		fd := openCdev(t, dev)
+
+		assert.True(t, strings.HasPrefix(fd.request(t, "reboot"), "ERR EINVAL "))
+		assert.True(t, strings.HasPrefix(fd.request(t, "ioctl RPROC_DO_SOMETHING"), "ERR ENOTTY "))
+	})
+
+	t.Run("shutdown on release is off by default", func(t *testing.T) {
+		r, dev := newCdevRemoteproc(t)
+		fd := openCdev(t, dev)
+
+		assert.Equal(t, "OK 0", fd.request(t, "ioctl RPROC_GET_SHUTDOWN_ON_RELEASE"))
+		fd.request(t, "start")
+		requireState(t, r, simulator.StateRunning)
+		fd.close(t)
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, simulator.StateRunning, r.State())
+	})
+
+	t.Run("closing the descriptor that booted the core shuts it down when asked to", func(t *testing.T) {
+		r, dev := newCdevRemoteproc(t)
+		fd := openCdev(t, dev)
+
+		assert.Equal(t, "OK", fd.request(t, "ioctl RPROC_SET_SHUTDOWN_ON_RELEASE 1"))
+		assert.Equal(t, "OK 1", fd.request(t, "ioctl RPROC_GET_SHUTDOWN_ON_RELEASE"))
+		fd.request(t, "start")
+		requireState(t, r, simulator.StateRunning)
+		fd.close(t)
+
+		requireState(t, r, simulator.StateOffline)
+	})
+
+	t.Run("closing another descriptor leaves the core running", func(t *testing.T) {
+		r, dev := newCdevRemoteproc(t)
+		owner := openCdev(t, dev)
+		other := openCdev(t, dev)
+
+		owner.request(t, "ioctl RPROC_SET_SHUTDOWN_ON_RELEASE 1")
+		owner.request(t, "start")
+		requireState(t, r, simulator.StateRunning)
+		other.request(t, "ioctl RPROC_SET_SHUTDOWN_ON_RELEASE 1")
+		other.close(t)
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, simulator.StateRunning, r.State())
+		owner.close(t)
+		requireState(t, r, simulator.StateOffline)
+	})
+
+	t.Run("ownership ends when the core is stopped by someone else", func(t *testing.T) {
+		r, dev := newCdevRemoteproc(t)
+		fd := openCdev(t, dev)
+		fd.request(t, "ioctl RPROC_SET_SHUTDOWN_ON_RELEASE 1")
+		fd.request(t, "start")
+		requireState(t, r, simulator.StateRunning)
+		require.NoError(t, r.Stop())
+		require.NoError(t, r.Start())
+		requireState(t, r, simulator.StateRunning)
+
+		fd.close(t)
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, simulator.StateRunning, r.State())
+	})
+
+	t.Run("the uevent file reports the device node", func(t *testing.T) {
+		r, _ := newCdevRemoteproc(t)
+
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "uevent"), "MAJOR=250\nMINOR=0\nDEVNAME=remoteproc0\nDEVTYPE=remoteproc\n")
+	})
+}
+
+type cdevFd struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func openCdev(t *testing.T, path string) *cdevFd {
+	t.Helper()
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &cdevFd{conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+func (fd *cdevFd) request(t *testing.T, request string) string {
+	t.Helper()
+	_, err := fd.conn.Write([]byte(request + "\n"))
+	require.NoError(t, err)
+	require.True(t, fd.scanner.Scan(), "no response to %q", request)
+	return fd.scanner.Text()
+}
+
+func (fd *cdevFd) close(t *testing.T) {
+	t.Helper()
+	require.NoError(t, fd.conn.Close())
+}
@@ -0,0 +1,194 @@
+package simulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const defaultSlowBootDelay = 5 * time.Second
+
+// Chaos injects random faults at the given rates, expressed as the mean
+// number of faults per minute. The faults go through the same transitions as
+// sysfs writes do: a crash only affects a running remote processor, and the
+// other faults apply to its next boot or stop.
+type Chaos struct {
+	// Seed makes the faults of a run reproducible. Each instance draws its
+	// faults from its own sequence, derived from Seed and its index.
+	Seed            uint64
+	CrashRate       float64
+	BootFailureRate float64
+	SlowBootRate    float64
+	StopFailureRate float64
+	// SlowBootDelay is how much longer a slow boot takes, defaults to 5s
+	SlowBootDelay time.Duration
+	// Log records the faults injected, if set
+	Log *ChaosLog
+}
+
+func (c Chaos) enabled() bool {
+	return c.CrashRate > 0 || c.BootFailureRate > 0 || c.SlowBootRate > 0 || c.StopFailureRate > 0
+}
+
+func (c Chaos) validate() error {
+	for _, rate := range []float64{c.CrashRate, c.BootFailureRate, c.SlowBootRate, c.StopFailureRate} {
+		if rate < 0 {
+			return errors.New("chaos rates must not be negative")
+		}
+	}
+	if c.SlowBootDelay < 0 {
+		return errors.New("chaos slow boot delay must not be negative")
+	}
+	return nil
+}
+
+// chaosStream draws the faults of one kind: the time between two of them
+// follows an exponential distribution. Faults are planned relative to the
+// creation of the remoteproc rather than to the previous one firing, so that
+// the timeline does not drift with the scheduling of timers.
+type chaosStream struct {
+	kind FaultKind
+	// rate is the mean number of faults per minute
+	rate float64
+	rand *rand.Rand
+	// at is when the next fault is planned
+	at    time.Duration
+	timer *loopTimer
+	// id tells a timer that already fired from the current one, as
+	// stopping the stream cannot take back a callback already queued
+	id uint64
+}
+
+func (s *chaosStream) next() time.Duration {
+	s.at += time.Duration(s.rand.ExpFloat64() / s.rate * float64(time.Minute))
+	return s.at
+}
+
+// startChaos plans faults from the given offset from the start of the
+// timeline on.
+func (r *Remoteproc) startChaos(chaos Chaos, from time.Duration) {
+	if chaos.SlowBootDelay == 0 {
+		chaos.SlowBootDelay = defaultSlowBootDelay
+	}
+	log.Printf("Chaos for %s with seed %d", r.name, chaos.Seed)
+	rates := []struct {
+		kind FaultKind
+		rate float64
+	}{
+		{FaultCrash, chaos.CrashRate},
+		{FaultBootFailure, chaos.BootFailureRate},
+		{FaultSlowBoot, chaos.SlowBootRate},
+		{FaultStopHang, chaos.StopFailureRate},
+	}
+	for i, rate := range rates {
+		if rate.rate == 0 {
+			continue
+		}
+		stream := &chaosStream{
+			kind: rate.kind,
+			rate: rate.rate,
+			at:   from,
+			rand: rand.New(rand.NewPCG(chaos.Seed, uint64(r.index)<<8|uint64(i))),
+		}
+		r.chaos = append(r.chaos, stream)
+		r.armChaos(stream, chaos)
+	}
+}
+
+func (r *Remoteproc) armChaos(stream *chaosStream, chaos Chaos) {
+	at := stream.next()
+	id := stream.id
+	stream.timer = r.afterFunc(time.Until(r.faults.start.Add(at)), func() {
+		if id != stream.id {
+			return
+		}
+		fault := Fault{Kind: stream.kind, After: at}
+		if stream.kind == FaultSlowBoot {
+			fault.Delay = chaos.SlowBootDelay
+		}
+		r.injectFault(fault)
+		if chaos.Log != nil {
+			if err := chaos.Log.record(r.index, r.name, fault); err != nil {
+				log.Printf("Failed to record chaos: %s", err)
+			}
+		}
+		r.armChaos(stream, chaos)
+	})
+}
+
+func (r *Remoteproc) stopChaos() {
+	for _, stream := range r.chaos {
+		stream.timer.Stop()
+		stream.timer = nil
+		stream.id++
+	}
+}
+
+// ChaosLog records injected faults in a scenario file, which replays them
+// when given as a scenario: each fault fires at the time it was injected.
+// It is safe for use by several instances.
+type ChaosLog struct {
+	path string
+
+	mu       sync.Mutex
+	scenario Scenario
+}
+
+// NewChaosLog creates an empty chaos log at path.
+func NewChaosLog(path string) (*ChaosLog, error) {
+	l := &ChaosLog{path: path}
+	if err := l.write(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *ChaosLog) record(index uint, name string, fault Fault) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := slices.IndexFunc(l.scenario.Instances, func(instance ScenarioInstance) bool {
+		return instance.Index == index
+	})
+	if i < 0 {
+		l.scenario.Instances = append(l.scenario.Instances, ScenarioInstance{Index: index, Name: name})
+		i = len(l.scenario.Instances) - 1
+	}
+	instance := &l.scenario.Instances[i]
+	instance.Faults = append(instance.Faults, ScenarioFault{
+		Kind:  fault.Kind,
+		After: Duration(fault.After),
+		Delay: Duration(fault.Delay),
+	})
+	return l.write()
+}
+
+// write replaces the log, so that it is a complete scenario at all times.
+func (l *ChaosLog) write() error {
+	data, err := json.MarshalIndent(l.scenario, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write chaos log: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write chaos log: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write chaos log: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return fmt.Errorf("failed to write chaos log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package simulator_test
+
+import (
+	"cmp"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChaos(t *testing.T) {
+	// About one fault every 10ms of each kind.
+	chaos := simulator.Chaos{Seed: 42, CrashRate: 6000, BootFailureRate: 6000, SlowBootRate: 6000, StopFailureRate: 6000}
+
+	// injectedFaults returns the first faults injected, in the order of the
+	// timeline.
+	injectedFaults := func(t *testing.T, chaos simulator.Chaos) []simulator.Fault {
+		t.Helper()
+		r := newTestRemoteproc(t, simulator.Config{RootDir: t.TempDir(), Name: "dsp0", Chaos: chaos})
+		require.Eventually(t, func() bool { return len(r.FaultLog()) >= 16 }, time.Second, time.Millisecond)
+		var faults []simulator.Fault
+		for _, record := range r.FaultLog() {
+			faults = append(faults, record.Fault)
+		}
+		slices.SortFunc(faults, func(a, b simulator.Fault) int { return cmp.Compare(a.After, b.After) })
+		return faults[:8]
+	}
+
+	t.Run("it injects the same faults given the same seed", func(t *testing.T) {
+		assert.Equal(t, injectedFaults(t, chaos), injectedFaults(t, chaos))
+	})
+
+	t.Run("it injects other faults given another seed", func(t *testing.T) {
+		other := chaos
+		other.Seed = 43
+
+		assert.NotEqual(t, injectedFaults(t, chaos), injectedFaults(t, other))
+	})
+
+	t.Run("it records the faults in a scenario that replays them", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "chaos.json")
+		chaosLog, err := simulator.NewChaosLog(path)
+		require.NoError(t, err)
+		chaos := simulator.Chaos{Seed: 1, SlowBootRate: 6000, SlowBootDelay: time.Second, Log: chaosLog}
+		r, err := simulator.NewRemoteproc(simulator.Config{RootDir: t.TempDir(), Index: 3, Name: "dsp3", Chaos: chaos})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return len(r.FaultLog()) >= 2 }, time.Second, time.Millisecond)
+		require.NoError(t, r.Close())
+
+		scenario, err := simulator.LoadScenario(path)
+		require.NoError(t, err)
+
+		require.Len(t, scenario.Instances, 1)
+		instance := scenario.Instances[0]
+		assert.Equal(t, uint(3), instance.Index)
+		assert.Equal(t, "dsp3", instance.Name)
+		require.Len(t, instance.Faults, len(r.FaultLog()))
+		for i, record := range r.FaultLog() {
+			fault := instance.Faults[i].Fault()
+			assert.Equal(t, simulator.FaultSlowBoot, fault.Kind)
+			assert.Equal(t, time.Second, fault.Delay)
+			assert.Equal(t, record.Fault.After, fault.After)
+		}
+	})
+
+	t.Run("it injects no more faults once stopped", func(t *testing.T) {
+		// A fault every 100µs or so, so that one is often due as chaos stops.
+		busy := simulator.Chaos{Seed: 7, StopFailureRate: 600_000}
+		config := simulator.Config{RootDir: t.TempDir(), Name: "dsp0", Chaos: busy}
+		r := newTestRemoteproc(t, config)
+
+		for range 20 {
+			require.Eventually(t, func() bool { return len(r.FaultLog()) > 0 }, time.Second, time.Millisecond)
+			config.Chaos = simulator.Chaos{}
+			require.NoError(t, r.Reconfigure(config))
+			count := len(r.FaultLog())
+			time.Sleep(2 * time.Millisecond)
+			require.Equal(t, count, len(r.FaultLog()))
+
+			snapshot, err := r.Snapshot()
+			require.NoError(t, err)
+			assert.Empty(t, snapshot.PendingTimers)
+			config.Chaos = busy
+			require.NoError(t, r.Reconfigure(config))
+		}
+	})
+
+	t.Run("it refuses negative rates", func(t *testing.T) {
+		_, err := simulator.NewRemoteproc(simulator.Config{RootDir: t.TempDir(), Name: "dsp0", Chaos: simulator.Chaos{CrashRate: -1}})
+
+		assert.ErrorContains(t, err, "chaos rates must not be negative")
+	})
+}
@@ -0,0 +1,59 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrInstanceExists is returned when the instance directory of a remoteproc
+// already exists and Config.AdoptExisting is not set.
+var ErrInstanceExists = errors.New("instance directory already exists")
+
+// CleanupPolicy selects what [Remoteproc.Close] removes.
+type CleanupPolicy string
+
+const (
+	// CleanupRemoveCreated removes the directories the remoteproc created,
+	// leaving those that existed before untouched
+	CleanupRemoveCreated CleanupPolicy = "remove-created"
+	// CleanupRemoveAll also removes the directories of the instance that
+	// existed before, such as an adopted instance directory
+	CleanupRemoveAll CleanupPolicy = "remove-all"
+	// CleanupKeep removes nothing, leaving the tree for inspection
+	CleanupKeep CleanupPolicy = "keep"
+)
+
+func (p CleanupPolicy) validate() error {
+	switch p {
+	case "", CleanupRemoveCreated, CleanupRemoveAll, CleanupKeep:
+		return nil
+	default:
+		return fmt.Errorf("unknown cleanup policy %q", p)
+	}
+}
+
+// Failed tells whether the remote processor failed: it could not be created,
+// it is crashed, or it did not stop on [Remoteproc.Shutdown].
+// It is safe to call from any goroutine.
+func (r *Remoteproc) Failed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.startFailed || r.stopFailed || r.state == StateCrashed
+}
+
+func (r *Remoteproc) cleanup() error {
+	policy := r.cleanupMode
+	if r.keepFailed && r.Failed() {
+		log.Printf("Keeping artifacts of failed %s in %s", r.name, r.fs.InstanceDir())
+		policy = CleanupKeep
+	}
+	switch policy {
+	case CleanupKeep:
+		return nil
+	case CleanupRemoveAll:
+		return r.fs.RemoveAll()
+	default:
+		return r.fs.Cleanup()
+	}
+}
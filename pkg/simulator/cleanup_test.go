@@ -0,0 +1,112 @@
+package simulator_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanup(t *testing.T) {
+	instanceDir := func(root string) string {
+		return filepath.Join(root, "sys", "class", "remoteproc", "remoteproc0")
+	}
+	closeRemoteproc := func(t *testing.T, config simulator.Config) {
+		t.Helper()
+		r, err := simulator.NewRemoteproc(config)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+	}
+
+	t.Run("it removes the directories it created by default", func(t *testing.T) {
+		root := t.TempDir()
+
+		closeRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0"})
+
+		assert.NoDirExists(t, filepath.Join(root, "sys"))
+		assert.NoDirExists(t, filepath.Join(root, "lib"))
+	})
+
+	t.Run("it keeps everything with the keep policy", func(t *testing.T) {
+		root := t.TempDir()
+
+		closeRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0", Cleanup: simulator.CleanupKeep})
+
+		assert.FileExists(t, filepath.Join(instanceDir(root), "state"))
+	})
+
+	t.Run("it refuses to overwrite an existing instance directory", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.MkdirAll(instanceDir(root), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(instanceDir(root), "state"), []byte("running"), 0644))
+
+		_, err := simulator.NewRemoteproc(simulator.Config{RootDir: root, Name: "dsp0", Cleanup: simulator.CleanupRemoveAll})
+
+		assert.ErrorIs(t, err, simulator.ErrInstanceExists)
+		assertFileContent(t, filepath.Join(instanceDir(root), "state"), "running")
+	})
+
+	t.Run("it adopts an existing instance directory, left in place on close", func(t *testing.T) {
+		root := t.TempDir()
+		closeRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0", Cleanup: simulator.CleanupKeep})
+
+		r, err := simulator.NewRemoteproc(simulator.Config{RootDir: root, Name: "dsp1", AdoptExisting: true})
+		require.NoError(t, err)
+		assertFileContent(t, filepath.Join(instanceDir(root), "name"), "dsp1")
+		require.NoError(t, r.Close())
+
+		assert.DirExists(t, instanceDir(root))
+	})
+
+	t.Run("it removes an adopted instance directory with the remove-all policy", func(t *testing.T) {
+		root := t.TempDir()
+		closeRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0", Cleanup: simulator.CleanupKeep})
+
+		closeRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0", AdoptExisting: true, Cleanup: simulator.CleanupRemoveAll})
+
+		assert.NoDirExists(t, instanceDir(root))
+		assert.DirExists(t, filepath.Join(root, "lib", "firmware"))
+	})
+
+	t.Run("it keeps the artifacts of a crashed remote processor when asked to", func(t *testing.T) {
+		root := t.TempDir()
+		r, err := simulator.NewRemoteproc(simulator.Config{
+			RootDir:                root,
+			Name:                   "dsp0",
+			DefaultFirmware:        "some-firmware.elf",
+			BootDelay:              time.Millisecond,
+			KeepArtifactsOnFailure: true,
+		})
+		require.NoError(t, err)
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+		require.NoError(t, r.Start())
+		requireState(t, r, simulator.StateRunning)
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultCrash}))
+		requireState(t, r, simulator.StateCrashed)
+
+		require.NoError(t, r.Close())
+
+		assert.True(t, r.Failed())
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "state"), "crashed")
+	})
+
+	t.Run("it cleans up after a remote processor that did not fail", func(t *testing.T) {
+		r, err := simulator.NewRemoteproc(simulator.Config{RootDir: t.TempDir(), Name: "dsp0", KeepArtifactsOnFailure: true})
+		require.NoError(t, err)
+
+		require.NoError(t, r.Close())
+
+		assert.False(t, r.Failed())
+		assert.NoDirExists(t, r.InstanceDir())
+	})
+
+	t.Run("it refuses unknown policies", func(t *testing.T) {
+		_, err := simulator.NewRemoteproc(simulator.Config{RootDir: t.TempDir(), Name: "dsp0", Cleanup: "shred"})
+
+		assert.ErrorContains(t, err, "unknown cleanup policy")
+	})
+}
@@ -0,0 +1,232 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultCompatible = "sim,remoteproc"
+	// mailboxPhandle is the phandle of the /mailbox controller shared by all
+	// instances of a root.
+	mailboxPhandle = 1
+)
+
+type deviceTreeConfig struct {
+	enabled    bool
+	compatible string
+	mailboxes  []string
+}
+
+// MemoryRegion is a reserved-memory region used by the remote processor.
+// The simulator does not translate addresses, so Address is both where the
+// region lives for the host and for the remote processor.
+type MemoryRegion struct {
+	Name    string
+	Address uint64
+	Size    uint64
+}
+
+func (m MemoryRegion) end() uint64 {
+	return m.Address + m.Size
+}
+
+func (m MemoryRegion) String() string {
+	return fmt.Sprintf("%s:%#x:%#x", m.Name, m.Address, m.Size)
+}
+
+// ParseMemoryRegion parses a region in the form name:address:size, where
+// numbers are decimal or prefixed with 0x.
+func ParseMemoryRegion(s string) (MemoryRegion, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return MemoryRegion{}, fmt.Errorf("invalid memory region %q: expected name:address:size", s)
+	}
+	address, err := strconv.ParseUint(parts[1], 0, 64)
+	if err != nil {
+		return MemoryRegion{}, fmt.Errorf("invalid memory region %q address: %w", s, err)
+	}
+	size, err := strconv.ParseUint(parts[2], 0, 64)
+	if err != nil {
+		return MemoryRegion{}, fmt.Errorf("invalid memory region %q size: %w", s, err)
+	}
+	region := MemoryRegion{Name: parts[0], Address: address, Size: size}
+	if err := validateMemoryRegions([]MemoryRegion{region}); err != nil {
+		return MemoryRegion{}, err
+	}
+	return region, nil
+}
+
+func validateMemoryRegions(regions []MemoryRegion) error {
+	for i, region := range regions {
+		if region.Name == "" || strings.ContainsAny(region.Name, "/@:") {
+			return fmt.Errorf("invalid memory region name %q", region.Name)
+		}
+		if region.Size == 0 {
+			return fmt.Errorf("memory region %s is empty", region.Name)
+		}
+		if region.end() < region.Address {
+			return fmt.Errorf("memory region %s overflows the address space", region.Name)
+		}
+		for _, other := range regions[:i] {
+			if other.Name == region.Name {
+				return fmt.Errorf("duplicate memory region %s", region.Name)
+			}
+			if region.Address < other.end() && other.Address < region.end() {
+				return fmt.Errorf("memory region %s overlaps %s", region.Name, other.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func validateMailboxes(names []string) error {
+	for i, name := range names {
+		if name == "" {
+			return errors.New("mailbox names must not be empty")
+		}
+		for _, other := range names[:i] {
+			if other == name {
+				return fmt.Errorf("duplicate mailbox %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+// dtProperty is a device-tree property, encoded as the kernel exports it in
+// /sys/firmware/devicetree/base.
+type dtProperty struct {
+	name  string
+	value []byte
+}
+
+// dtNode is a node below /sys/firmware/devicetree/base, path being relative
+// to it.
+type dtNode struct {
+	path       string
+	properties []dtProperty
+}
+
+// dtStrings encodes a string or a string list: each entry is NUL terminated.
+func dtStrings(values ...string) []byte {
+	var b []byte
+	for _, v := range values {
+		b = append(b, v...)
+		b = append(b, 0)
+	}
+	return b
+}
+
+// dtCells encodes big-endian 32-bit cells.
+func dtCells(cells ...uint32) []byte {
+	b := make([]byte, 0, 4*len(cells))
+	for _, c := range cells {
+		b = binary.BigEndian.AppendUint32(b, c)
+	}
+	return b
+}
+
+// dtU64 splits a 64-bit number in two cells, as with #address-cells = <2>.
+func dtU64(v uint64) []uint32 {
+	return []uint32{uint32(v >> 32), uint32(v)}
+}
+
+func dtRootProperties() []dtProperty {
+	return []dtProperty{
+		{"#address-cells", dtCells(2)},
+		{"#size-cells", dtCells(2)},
+		{"compatible", dtStrings("sim,remoteproc-simulator")},
+		{"model", dtStrings("Remoteproc Simulator")},
+	}
+}
+
+// deviceTreeNodes describes the remote processor, its reserved-memory
+// regions and the mailbox controller it uses. Phandles are derived from the
+// index so that instances sharing a root do not collide.
+func (r *Remoteproc) deviceTreeNodes() []dtNode {
+	phandleBase := uint32(r.index+1) << 8
+	nodes := []dtNode{{
+		path: "reserved-memory",
+		properties: []dtProperty{
+			{"name", dtStrings("reserved-memory")},
+			{"#address-cells", dtCells(2)},
+			{"#size-cells", dtCells(2)},
+			{"ranges", nil},
+		},
+	}}
+
+	var regionPhandles []uint32
+	var regionNames []string
+	for i, region := range r.memoryRegions {
+		phandle := phandleBase + uint32(i) + 1
+		regionPhandles = append(regionPhandles, phandle)
+		regionNames = append(regionNames, region.Name)
+		nodes = append(nodes, dtNode{
+			path: fmt.Sprintf("reserved-memory/%s@%x", region.Name, region.Address),
+			properties: []dtProperty{
+				{"name", dtStrings(region.Name)},
+				{"compatible", dtStrings("shared-dma-pool")},
+				{"reg", dtCells(append(dtU64(region.Address), dtU64(region.Size)...)...)},
+				{"no-map", nil},
+				{"phandle", dtCells(phandle)},
+			},
+		})
+	}
+
+	if len(r.deviceTree.mailboxes) > 0 {
+		nodes = append(nodes, dtNode{
+			path: "mailbox",
+			properties: []dtProperty{
+				{"name", dtStrings("mailbox")},
+				{"compatible", dtStrings("sim,mailbox")},
+				{"#mbox-cells", dtCells(1)},
+				{"phandle", dtCells(mailboxPhandle)},
+			},
+		})
+	}
+
+	instanceName := r.fs.InstanceName()
+	properties := []dtProperty{
+		{"name", dtStrings(instanceName)},
+		{"compatible", dtStrings(r.deviceTree.compatible)},
+		{"status", dtStrings("okay")},
+		{"phandle", dtCells(phandleBase)},
+	}
+	if r.firmware != "" {
+		properties = append(properties, dtProperty{"firmware-name", dtStrings(r.firmware)})
+	}
+	if len(regionPhandles) > 0 {
+		properties = append(properties,
+			dtProperty{"memory-region", dtCells(regionPhandles...)},
+			dtProperty{"memory-region-names", dtStrings(regionNames...)},
+		)
+	}
+	if len(r.deviceTree.mailboxes) > 0 {
+		var mboxes []uint32
+		for channel := range r.deviceTree.mailboxes {
+			mboxes = append(mboxes, mailboxPhandle, uint32(r.index)<<8|uint32(channel))
+		}
+		properties = append(properties,
+			dtProperty{"mboxes", dtCells(mboxes...)},
+			dtProperty{"mbox-names", dtStrings(r.deviceTree.mailboxes...)},
+		)
+	}
+	return append(nodes, dtNode{path: instanceName, properties: properties})
+}
+
+func (r *Remoteproc) bootstrapDeviceTree() error {
+	if err := r.fs.BootstrapDeviceTree(dtRootProperties()); err != nil {
+		return err
+	}
+	nodes := r.deviceTreeNodes()
+	for _, node := range nodes {
+		if err := r.fs.WriteDeviceTreeNode(node.path, node.properties); err != nil {
+			return err
+		}
+	}
+	return r.fs.LinkOfNode(nodes[len(nodes)-1].path)
+}
@@ -0,0 +1,125 @@
+package simulator_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceTree(t *testing.T) {
+	newDeviceTreeConfig := func(t *testing.T) simulator.Config {
+		return simulator.Config{
+			RootDir:         t.TempDir(),
+			Name:            "dsp0",
+			DeviceTree:      true,
+			DefaultFirmware: "dsp0.elf",
+			MemoryRegions: []simulator.MemoryRegion{
+				{Name: "vdev0buffer", Address: 0x9_4000_0000, Size: 0x100000},
+				{Name: "dsp-code", Address: 0x4000_0000, Size: 0x800000},
+			},
+			Mailboxes: []string{"tx", "rx"},
+		}
+	}
+
+	t.Run("it describes the remote processor with binary encoded properties", func(t *testing.T) {
+		config := newDeviceTreeConfig(t)
+		newTestRemoteproc(t, config)
+		node := filepath.Join(config.RootDir, "sys", "firmware", "devicetree", "base", "remoteproc0")
+
+		assertFileContent(t, filepath.Join(node, "name"), "remoteproc0\x00")
+		assertFileContent(t, filepath.Join(node, "compatible"), "sim,remoteproc\x00")
+		assertFileContent(t, filepath.Join(node, "firmware-name"), "dsp0.elf\x00")
+		assertFileContent(t, filepath.Join(node, "phandle"), "\x00\x00\x01\x00")
+		assertFileContent(t, filepath.Join(node, "memory-region"), "\x00\x00\x01\x01\x00\x00\x01\x02")
+		assertFileContent(t, filepath.Join(node, "memory-region-names"), "vdev0buffer\x00dsp-code\x00")
+		assertFileContent(t, filepath.Join(node, "mboxes"), "\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x01")
+		assertFileContent(t, filepath.Join(node, "mbox-names"), "tx\x00rx\x00")
+	})
+
+	t.Run("it describes memory regions as reserved-memory nodes", func(t *testing.T) {
+		config := newDeviceTreeConfig(t)
+		newTestRemoteproc(t, config)
+		node := filepath.Join(config.RootDir, "sys", "firmware", "devicetree", "base", "reserved-memory", "vdev0buffer@940000000")
+
+		assertFileContent(t, filepath.Join(node, "compatible"), "shared-dma-pool\x00")
+		assertFileContent(t, filepath.Join(node, "reg"), "\x00\x00\x00\x09\x40\x00\x00\x00\x00\x00\x00\x00\x00\x10\x00\x00")
+		assertFileContent(t, filepath.Join(node, "no-map"), "")
+		assertFileContent(t, filepath.Join(node, "phandle"), "\x00\x00\x01\x01")
+	})
+
+	t.Run("it links the device to its node", func(t *testing.T) {
+		config := newDeviceTreeConfig(t)
+		r := newTestRemoteproc(t, config)
+
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "of_node", "name"), "remoteproc0\x00")
+		assertFileContent(t, filepath.Join(config.RootDir, "proc", "device-tree", "remoteproc0", "name"), "remoteproc0\x00")
+	})
+
+	t.Run("instances sharing a root get distinct phandles and mailbox channels", func(t *testing.T) {
+		config := newDeviceTreeConfig(t)
+		newTestRemoteproc(t, config)
+		config.Index = 1
+		config.MemoryRegions = []simulator.MemoryRegion{{Name: "m4-code", Address: 0x5000_0000, Size: 0x1000}}
+		config.Mailboxes = []string{"kick"}
+		newTestRemoteproc(t, config)
+		base := filepath.Join(config.RootDir, "sys", "firmware", "devicetree", "base")
+
+		assertFileContent(t, filepath.Join(base, "remoteproc1", "phandle"), "\x00\x00\x02\x00")
+		assertFileContent(t, filepath.Join(base, "remoteproc1", "memory-region"), "\x00\x00\x02\x01")
+		assertFileContent(t, filepath.Join(base, "remoteproc1", "mboxes"), "\x00\x00\x00\x01\x00\x00\x01\x00")
+		assertFileContent(t, filepath.Join(base, "reserved-memory", "m4-code@50000000", "phandle"), "\x00\x00\x02\x01")
+	})
+
+	t.Run("it removes the nodes of the instance on close", func(t *testing.T) {
+		config := newDeviceTreeConfig(t)
+		require.NoError(t, os.MkdirAll(filepath.Join(config.RootDir, "sys", "firmware", "devicetree", "base"), 0755))
+		r, err := simulator.NewRemoteproc(config)
+		require.NoError(t, err)
+
+		require.NoError(t, r.Close())
+
+		assert.NoDirExists(t, filepath.Join(config.RootDir, "sys", "firmware", "devicetree", "base", "remoteproc0"))
+		assert.NoDirExists(t, filepath.Join(config.RootDir, "sys", "firmware", "devicetree", "base", "reserved-memory"))
+	})
+
+	t.Run("it is not created by default", func(t *testing.T) {
+		root := t.TempDir()
+		r := newTestRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0"})
+
+		assert.NoDirExists(t, filepath.Join(root, "sys", "firmware"))
+		assert.NoFileExists(t, filepath.Join(r.InstanceDir(), "of_node"))
+	})
+}
+
+func TestMemoryRegions(t *testing.T) {
+	t.Run("it parses name:address:size", func(t *testing.T) {
+		region, err := simulator.ParseMemoryRegion("dsp-code:0x40000000:8388608")
+
+		require.NoError(t, err)
+		assert.Equal(t, simulator.MemoryRegion{Name: "dsp-code", Address: 0x4000_0000, Size: 0x800000}, region)
+	})
+
+	t.Run("it rejects malformed regions", func(t *testing.T) {
+		for _, s := range []string{"dsp-code", "dsp-code:0x1000", ":0x1000:0x1000", "dsp-code:zero:0x1000", "dsp-code:0x1000:0"} {
+			_, err := simulator.ParseMemoryRegion(s)
+			assert.Error(t, err, s)
+		}
+	})
+
+	t.Run("it rejects overlapping regions", func(t *testing.T) {
+		_, err := simulator.NewRemoteproc(simulator.Config{
+			RootDir: t.TempDir(),
+			Name:    "dsp0",
+			MemoryRegions: []simulator.MemoryRegion{
+				{Name: "a", Address: 0x1000, Size: 0x1000},
+				{Name: "b", Address: 0x1800, Size: 0x1000},
+			},
+		})
+
+		assert.ErrorContains(t, err, "memory region b overlaps a")
+	})
+}
@@ -0,0 +1,377 @@
+package simulator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const doorbellCountersFileName = "kicks"
+
+// doorbellQueueSize is how many notifications wait for delivery at most.
+const doorbellQueueSize = 256
+
+// ErrNoDoorbell is returned by doorbell methods of a [Remoteproc] created
+// without Config.Doorbell.
+var ErrNoDoorbell = errors.New("doorbell is not enabled")
+
+// DoorbellFaults are faults injected in notifications, in both directions.
+type DoorbellFaults struct {
+	// Delay postpones the delivery of every notification
+	Delay time.Duration
+	// DropRate is the probability, from 0 to 1, that a notification is lost
+	DropRate float64
+}
+
+func (f DoorbellFaults) validate() error {
+	if f.Delay < 0 {
+		return errors.New("doorbell delay must not be negative")
+	}
+	if f.DropRate < 0 || f.DropRate > 1 {
+		return fmt.Errorf("doorbell drop rate %g is not between 0 and 1", f.DropRate)
+	}
+	return nil
+}
+
+type doorbellConfig struct {
+	enabled bool
+	faults  DoorbellFaults
+}
+
+type doorbellDirection int
+
+const (
+	// toRemote is a kick of the host, rproc_ops.kick
+	toRemote doorbellDirection = iota
+	// toHost is an interrupt of the remote processor
+	toHost
+)
+
+type doorbellNotification struct {
+	direction doorbellDirection
+	notifyID  uint32
+	due       time.Time
+}
+
+type kickCounters struct {
+	toRemote, toHost               uint64
+	droppedToRemote, droppedToHost uint64
+}
+
+// doorbell emulates the mailbox a remoteproc driver kicks vrings through and
+// receives interrupts from. The host side is a Unix socket on which each line
+// is the notify ID of a vring: lines the host writes are kicks, and lines the
+// simulator writes are interrupts from the remote processor. Behaviour
+// plugins play the remote side through hooks.
+//
+// Notifications are delivered in order by a single goroutine, so hooks may
+// block it but should not wait for the delivery of another notification.
+// A notification that finds the queue full is dropped and counted as such,
+// which lets hooks kick and interrupt without deadlocking the goroutine. So is
+// a kick delivered while the remote processor is down.
+type doorbell struct {
+	listener      net.Listener
+	writeCounters func(content string) error
+	// wake is called before a kick reaches the remote processor, and tells
+	// whether it is up to take it. Only then does the kick count as activity
+	// for runtime PM.
+	wake func() bool
+
+	mu        sync.Mutex
+	conns     map[net.Conn]struct{}
+	faults    DoorbellFaults
+	rand      *rand.Rand
+	kickHooks []func(notifyID uint32)
+	counters  map[uint32]*kickCounters
+
+	queue chan doorbellNotification
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newDoorbell(path string, faults DoorbellFaults, writeCounters func(string) error, wake func() bool) (*doorbell, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale doorbell %s: %w", path, err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create doorbell %s: %w", path, err)
+	}
+	d := &doorbell{
+		listener:      listener,
+		writeCounters: writeCounters,
+		wake:          wake,
+		conns:         map[net.Conn]struct{}{},
+		faults:        faults,
+		rand:          rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		counters:      map[uint32]*kickCounters{},
+		queue:         make(chan doorbellNotification, doorbellQueueSize),
+		done:          make(chan struct{}),
+	}
+	if err := d.writeCounters(d.countersContent()); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	d.wg.Add(2)
+	go d.accept()
+	go d.dispatch()
+	return d, nil
+}
+
+// Close removes the socket, drops connections and discards notifications
+// that are still pending.
+func (d *doorbell) Close() error {
+	close(d.done)
+	err := d.listener.Close()
+	d.mu.Lock()
+	for conn := range d.conns {
+		conn.Close()
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+	return err
+}
+
+func (d *doorbell) accept() {
+	defer d.wg.Done()
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		d.conns[conn] = struct{}{}
+		d.mu.Unlock()
+		d.wg.Add(1)
+		go d.serve(conn)
+	}
+}
+
+func (d *doorbell) serve(conn net.Conn) {
+	defer d.wg.Done()
+	defer func() {
+		d.mu.Lock()
+		delete(d.conns, conn)
+		d.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		notifyID, err := strconv.ParseUint(line, 10, 32)
+		if err != nil {
+			log.Printf("Ignoring invalid kick %q", line)
+			continue
+		}
+		d.notify(toRemote, uint32(notifyID))
+	}
+}
+
+// notify queues a notification. Kicks are counted once they reach the remote
+// processor or are dropped, interrupts as soon as they are queued.
+func (d *doorbell) notify(direction doorbellDirection, notifyID uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dropped := d.faults.DropRate > 0 && d.rand.Float64() < d.faults.DropRate
+	if !dropped {
+		select {
+		case d.queue <- doorbellNotification{direction: direction, notifyID: notifyID, due: time.Now().Add(d.faults.Delay)}:
+		default:
+			log.Printf("Doorbell queue full, dropping notification %d", notifyID)
+			dropped = true
+		}
+	}
+	switch {
+	case direction == toRemote && dropped:
+		d.count(notifyID, func(c *kickCounters) { c.droppedToRemote++ })
+	case direction == toRemote:
+		// Counted on delivery.
+	case dropped:
+		d.count(notifyID, func(c *kickCounters) { c.droppedToHost++ })
+	default:
+		d.count(notifyID, func(c *kickCounters) { c.toHost++ })
+	}
+}
+
+// count updates the counters of notifyID and writes them out. It must be
+// called with d.mu held.
+func (d *doorbell) count(notifyID uint32, update func(*kickCounters)) {
+	counters := d.counters[notifyID]
+	if counters == nil {
+		counters = &kickCounters{}
+		d.counters[notifyID] = counters
+	}
+	update(counters)
+	if err := d.writeCounters(d.countersContent()); err != nil {
+		log.Printf("Failed to update kick counters: %s", err)
+	}
+}
+
+func (d *doorbell) dispatch() {
+	defer d.wg.Done()
+	for {
+		select {
+		case n := <-d.queue:
+			if wait := time.Until(n.due); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-d.done:
+					timer.Stop()
+					return
+				}
+			}
+			d.deliver(n)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *doorbell) deliver(n doorbellNotification) {
+	d.mu.Lock()
+	hooks := slices.Clone(d.kickHooks)
+	conns := make([]net.Conn, 0, len(d.conns))
+	for conn := range d.conns {
+		conns = append(conns, conn)
+	}
+	d.mu.Unlock()
+
+	if n.direction == toRemote {
+		up := d.wake()
+		d.mu.Lock()
+		d.count(n.notifyID, func(c *kickCounters) {
+			if up {
+				c.toRemote++
+			} else {
+				c.droppedToRemote++
+			}
+		})
+		d.mu.Unlock()
+		if !up {
+			log.Printf("Dropping kick %d of a remote processor that is down", n.notifyID)
+			return
+		}
+		for _, hook := range hooks {
+			hook(n.notifyID)
+		}
+		return
+	}
+	for _, conn := range conns {
+		if _, err := fmt.Fprintln(conn, n.notifyID); err != nil {
+			log.Printf("Failed to deliver interrupt %d: %s", n.notifyID, err)
+		}
+	}
+}
+
+// countersContent formats the kick counters, one line per notify ID. It must
+// be called with d.mu held.
+func (d *doorbell) countersContent() string {
+	var b strings.Builder
+	b.WriteString("notifyid to_remote to_host dropped_to_remote dropped_to_host\n")
+	ids := make([]uint32, 0, len(d.counters))
+	for id := range d.counters {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		c := d.counters[id]
+		fmt.Fprintf(&b, "%d %d %d %d %d\n", id, c.toRemote, c.toHost, c.droppedToRemote, c.droppedToHost)
+	}
+	return b.String()
+}
+
+func (r *Remoteproc) startDoorbell() error {
+	if err := r.fs.BootstrapInstanceRunDirectory(); err != nil {
+		return err
+	}
+	if err := r.fs.BootstrapDebugfs(); err != nil {
+		return err
+	}
+	writeCounters := func(content string) error {
+		return r.fs.WriteDebugfsFile(doorbellCountersFileName, content)
+	}
+	wake := func() bool {
+		var up bool
+		r.send(func() error {
+			up = r.state.isUp()
+			if up {
+				r.markActive()
+			}
+			return nil
+		})
+		return up
+	}
+	doorbell, err := newDoorbell(r.fs.DoorbellPath(), r.doorbellConfig.faults, writeCounters, wake)
+	if err != nil {
+		return err
+	}
+	r.doorbell = doorbell
+	return nil
+}
+
+// Kick notifies the remote processor that the vring with the given notify ID
+// has buffers, as the host does through rproc_ops.kick.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) Kick(notifyID uint32) error {
+	if r.doorbell == nil {
+		return ErrNoDoorbell
+	}
+	r.doorbell.notify(toRemote, notifyID)
+	return nil
+}
+
+// Interrupt notifies the host that the vring with the given notify ID has
+// used buffers, as the remote processor does through its mailbox.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) Interrupt(notifyID uint32) error {
+	if r.doorbell == nil {
+		return ErrNoDoorbell
+	}
+	r.doorbell.notify(toHost, notifyID)
+	return nil
+}
+
+// AddKickHook registers a function called with the notify ID of each kick
+// that reaches the remote processor, which lets behaviour plugins play the
+// firmware. Hooks run one kick at a time, in order. They may call Kick and
+// Interrupt, whose notifications are dropped if the queue is full.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) AddKickHook(hook func(notifyID uint32)) error {
+	if r.doorbell == nil {
+		return ErrNoDoorbell
+	}
+	r.doorbell.mu.Lock()
+	defer r.doorbell.mu.Unlock()
+	r.doorbell.kickHooks = append(r.doorbell.kickHooks, hook)
+	return nil
+}
+
+// SetDoorbellFaults changes the faults injected in notifications sent from
+// now on.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) SetDoorbellFaults(faults DoorbellFaults) error {
+	if r.doorbell == nil {
+		return ErrNoDoorbell
+	}
+	if err := faults.validate(); err != nil {
+		return err
+	}
+	r.doorbell.mu.Lock()
+	defer r.doorbell.mu.Unlock()
+	r.doorbell.faults = faults
+	return nil
+}
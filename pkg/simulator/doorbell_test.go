@@ -0,0 +1,159 @@
+package simulator_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoorbell(t *testing.T) {
+	dialDoorbell := func(t *testing.T, root string) net.Conn {
+		t.Helper()
+		conn, err := net.Dial("unix", filepath.Join(root, "run", "remoteproc0", "doorbell"))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	kicksFile := func(root string) string {
+		return filepath.Join(root, "sys", "kernel", "debug", "remoteproc", "remoteproc0", "kicks")
+	}
+	recordKicks := func(t *testing.T, r *simulator.Remoteproc) chan uint32 {
+		kicks := make(chan uint32, 16)
+		require.NoError(t, r.AddKickHook(func(notifyID uint32) { kicks <- notifyID }))
+		return kicks
+	}
+
+	t.Run("kicks of the host reach hooks and are counted", func(t *testing.T) {
+		root := t.TempDir()
+		r := newRunningRemoteproc(t, simulator.Config{RootDir: root, Doorbell: true})
+		kicks := recordKicks(t, r)
+		host := dialDoorbell(t, root)
+
+		_, err := fmt.Fprintln(host, "1")
+		require.NoError(t, err)
+
+		select {
+		case notifyID := <-kicks:
+			assert.Equal(t, uint32(1), notifyID)
+		case <-time.After(time.Second):
+			t.Fatal("kick not delivered")
+		}
+		assertFileContent(t, kicksFile(root), "notifyid to_remote to_host dropped_to_remote dropped_to_host\n1 1 0 0 0\n")
+	})
+
+	t.Run("interrupts of the remote processor reach the host", func(t *testing.T) {
+		root := t.TempDir()
+		r := newRunningRemoteproc(t, simulator.Config{RootDir: root, Doorbell: true})
+		kicks := recordKicks(t, r)
+		host := dialDoorbell(t, root)
+		// A kick makes sure the simulator knows about the connection.
+		_, err := fmt.Fprintln(host, "1")
+		require.NoError(t, err)
+		<-kicks
+
+		require.NoError(t, r.Interrupt(0))
+
+		require.NoError(t, host.SetReadDeadline(time.Now().Add(time.Second)))
+		line, err := bufio.NewReader(host).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "0\n", line)
+	})
+
+	t.Run("dropped notifications are counted but not delivered", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{Doorbell: true, DoorbellFaults: simulator.DoorbellFaults{DropRate: 1}})
+		kicks := recordKicks(t, r)
+
+		require.NoError(t, r.Kick(0))
+		require.NoError(t, r.Interrupt(0))
+
+		select {
+		case <-kicks:
+			t.Fatal("dropped kick delivered")
+		case <-time.After(50 * time.Millisecond):
+		}
+		assertFileContent(t, kicksFile(root), "notifyid to_remote to_host dropped_to_remote dropped_to_host\n0 0 0 1 1\n")
+	})
+
+	t.Run("kicks are dropped while the remote processor is down", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{Doorbell: true})
+		kicks := recordKicks(t, r)
+
+		require.NoError(t, r.Kick(0))
+
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			content, err := os.ReadFile(kicksFile(root))
+			assert.NoError(c, err)
+			assert.Equal(c, "notifyid to_remote to_host dropped_to_remote dropped_to_host\n0 0 0 1 0\n", string(content))
+		}, time.Second, 10*time.Millisecond)
+		assert.Empty(t, kicks)
+		assert.Equal(t, simulator.StateOffline, r.State())
+	})
+
+	t.Run("notifications beyond a full queue are dropped", func(t *testing.T) {
+		root := t.TempDir()
+		r := newRunningRemoteproc(t, simulator.Config{RootDir: root, Doorbell: true})
+		done := make(chan struct{})
+		require.NoError(t, r.AddKickHook(func(notifyID uint32) {
+			if notifyID != 0 {
+				return
+			}
+			defer close(done)
+			for range 300 {
+				assert.NoError(t, r.Interrupt(1))
+			}
+		}))
+
+		require.NoError(t, r.Kick(0))
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("hook blocked on a full queue")
+		}
+		assertFileContent(t, kicksFile(root), "notifyid to_remote to_host dropped_to_remote dropped_to_host\n"+
+			"0 1 0 0 0\n1 0 256 0 44\n")
+	})
+
+	t.Run("notifications are delayed and delivered in order", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{Doorbell: true})
+		kicks := recordKicks(t, r)
+		require.NoError(t, r.SetDoorbellFaults(simulator.DoorbellFaults{Delay: 50 * time.Millisecond}))
+
+		start := time.Now()
+		for notifyID := range uint32(3) {
+			require.NoError(t, r.Kick(notifyID))
+		}
+
+		for want := range uint32(3) {
+			select {
+			case notifyID := <-kicks:
+				assert.Equal(t, want, notifyID)
+			case <-time.After(time.Second):
+				t.Fatal("kick not delivered")
+			}
+		}
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("it rejects invalid drop rates", func(t *testing.T) {
+		_, err := simulator.NewRemoteproc(simulator.Config{RootDir: t.TempDir(), Name: "dsp0", Doorbell: true, DoorbellFaults: simulator.DoorbellFaults{DropRate: 2}})
+
+		assert.ErrorContains(t, err, "doorbell drop rate 2 is not between 0 and 1")
+	})
+
+	t.Run("it is not created by default", func(t *testing.T) {
+		root := t.TempDir()
+		r := newTestRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0"})
+
+		assert.ErrorIs(t, r.Kick(0), simulator.ErrNoDoorbell)
+		assert.NoFileExists(t, filepath.Join(root, "run", "remoteproc0", "doorbell"))
+	})
+}
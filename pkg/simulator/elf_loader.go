@@ -0,0 +1,237 @@
+package simulator
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"syscall"
+)
+
+// Resource types of the remoteproc resource table, as in
+// include/linux/remoteproc.h.
+const (
+	rscCarveout    = 0
+	rscDevmem      = 1
+	rscTrace       = 2
+	rscVdev        = 3
+	rscLast        = 4
+	rscVendorStart = 128
+	rscVendorEnd   = 512
+
+	// fwRscAddrAny lets the host pick the address of a resource.
+	fwRscAddrAny = 0xffffffff
+
+	resourceTableSection = ".resource_table"
+	// resourceTableHeaderSize covers ver, num and reserved[2].
+	resourceTableHeaderSize = 16
+	// rscHeaderSize is the size of struct fw_rsc_hdr.
+	rscHeaderSize = 4
+	// rscCarveoutSize is the size of struct fw_rsc_carveout.
+	rscCarveoutSize = 52
+	rscNameSize     = 32
+	// rscVdevSize is the size of struct fw_rsc_vdev, without its vrings and
+	// config space.
+	rscVdevSize = 24
+	// rscVdevVringSize is the size of struct fw_rsc_vdev_vring.
+	rscVdevVringSize = 20
+	// maxVdevVrings is the number of vrings a vdev may have.
+	maxVdevVrings = 2
+	elf32ShdrSize = 40
+)
+
+// rscByteOrder is the byte order of firmware images and of their resource
+// table. Like the kernel, the simulator expects firmware to have the
+// endianness of the host, which it emulates as little-endian.
+var rscByteOrder = binary.LittleEndian
+
+// parseFirmwareELF applies the checks of rproc_elf_sanity_check before
+// parsing the image.
+func parseFirmwareELF(data []byte) (*elf.File, error) {
+	if len(data) < 52 {
+		return nil, fmt.Errorf("Image is too small: %w", syscall.EINVAL)
+	}
+	if !bytes.HasPrefix(data, []byte(elf.ELFMAG)) {
+		return nil, fmt.Errorf("Image is corrupted (bad magic): %w", syscall.EINVAL)
+	}
+
+	var shoff, phoff uint64
+	var phnum uint16
+	switch class := elf.Class(data[elf.EI_CLASS]); class {
+	case elf.ELFCLASS32:
+		phoff = uint64(rscByteOrder.Uint32(data[28:]))
+		shoff = uint64(rscByteOrder.Uint32(data[32:]))
+		phnum = rscByteOrder.Uint16(data[44:])
+	case elf.ELFCLASS64:
+		if len(data) < 64 {
+			return nil, fmt.Errorf("elf64 header is too small: %w", syscall.EINVAL)
+		}
+		phoff = rscByteOrder.Uint64(data[32:])
+		shoff = rscByteOrder.Uint64(data[40:])
+		phnum = rscByteOrder.Uint16(data[56:])
+	default:
+		return nil, fmt.Errorf("Unsupported class: %d: %w", class, syscall.EINVAL)
+	}
+	if elf.Data(data[elf.EI_DATA]) != elf.ELFDATA2LSB {
+		return nil, fmt.Errorf("Unsupported firmware endianness: %w", syscall.EINVAL)
+	}
+	if uint64(len(data)) < shoff+elf32ShdrSize {
+		return nil, fmt.Errorf("Image is too small: %w", syscall.EINVAL)
+	}
+	if phnum == 0 {
+		return nil, fmt.Errorf("No loadable segments: %w", syscall.EINVAL)
+	}
+	if phoff > uint64(len(data)) {
+		return nil, fmt.Errorf("Firmware size is too small: %w", syscall.EINVAL)
+	}
+
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ELF image: %s: %w", err, syscall.EINVAL)
+	}
+	return f, nil
+}
+
+// resourceTable is the cached copy of a firmware's resource table, which
+// resource handlers update with the addresses the host picked.
+type resourceTable struct {
+	data []byte
+	// addr is the device address the table is loaded at
+	addr uint64
+}
+
+// findResourceTable returns the resource table of the image, or nil when it
+// has none. Its checks are the ones of the kernel's find_table.
+func findResourceTable(f *elf.File, data []byte) (*resourceTable, error) {
+	section := f.Section(resourceTableSection)
+	if section == nil {
+		return nil, nil
+	}
+	offset, size := section.Offset, section.Size
+	if offset+size > uint64(len(data)) || offset+size < size {
+		return nil, fmt.Errorf("resource table truncated: %w", syscall.EINVAL)
+	}
+	if size < resourceTableHeaderSize {
+		return nil, fmt.Errorf("header-less resource table: %w", syscall.EINVAL)
+	}
+	table := &resourceTable{data: bytes.Clone(data[offset : offset+size]), addr: section.Addr}
+	if ver := table.u32(0); ver != 1 {
+		return nil, fmt.Errorf("unsupported fw ver: %d: %w", ver, syscall.EINVAL)
+	}
+	if table.u32(8) != 0 || table.u32(12) != 0 {
+		return nil, fmt.Errorf("non zero reserved bytes: %w", syscall.EINVAL)
+	}
+	if resourceTableHeaderSize+4*uint64(table.num()) > size {
+		return nil, fmt.Errorf("resource table incomplete: %w", syscall.EINVAL)
+	}
+	return table, nil
+}
+
+func (t *resourceTable) num() uint32 {
+	return t.u32(4)
+}
+
+// entryOffset returns the offset of the header of the i-th resource.
+func (t *resourceTable) entryOffset(i uint32) uint32 {
+	return t.u32(resourceTableHeaderSize + 4*i)
+}
+
+func (t *resourceTable) u32(offset uint32) uint32 {
+	return rscByteOrder.Uint32(t.data[offset:])
+}
+
+func (t *resourceTable) putU32(offset, v uint32) {
+	rscByteOrder.PutUint32(t.data[offset:], v)
+}
+
+// rscName decodes a NUL-padded name field.
+func (t *resourceTable) rscName(offset uint32) string {
+	name := t.data[offset : offset+rscNameSize]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return string(name)
+}
+
+// fwRscCarveout is struct fw_rsc_carveout, offset being where it lives in
+// the resource table.
+type fwRscCarveout struct {
+	offset uint32
+	da     uint32
+	pa     uint32
+	len    uint32
+	flags  uint32
+	name   string
+}
+
+func (t *resourceTable) carveout(offset uint32, avail int) (fwRscCarveout, error) {
+	if avail < rscCarveoutSize {
+		return fwRscCarveout{}, fmt.Errorf("carveout rsc is truncated: %w", syscall.EINVAL)
+	}
+	if t.u32(offset+16) != 0 {
+		return fwRscCarveout{}, fmt.Errorf("carveout rsc has non zero reserved bytes: %w", syscall.EINVAL)
+	}
+	return fwRscCarveout{
+		offset: offset,
+		da:     t.u32(offset),
+		pa:     t.u32(offset + 4),
+		len:    t.u32(offset + 8),
+		flags:  t.u32(offset + 12),
+		name:   t.rscName(offset + 20),
+	}, nil
+}
+
+// fwRscVdevVring is struct fw_rsc_vdev_vring, offset being where it lives in
+// the resource table.
+type fwRscVdevVring struct {
+	offset uint32
+	da     uint32
+	align  uint32
+	num    uint32
+}
+
+// fwRscVdev is struct fw_rsc_vdev, offset being where it lives in the
+// resource table.
+type fwRscVdev struct {
+	offset    uint32
+	id        uint32
+	dfeatures uint32
+	configLen uint32
+	vrings    []fwRscVdevVring
+}
+
+func (t *resourceTable) vdev(offset uint32, avail int) (fwRscVdev, error) {
+	if avail < rscVdevSize {
+		return fwRscVdev{}, fmt.Errorf("vdev rsc is truncated: %w", syscall.EINVAL)
+	}
+	rsc := fwRscVdev{
+		offset:    offset,
+		id:        t.u32(offset),
+		dfeatures: t.u32(offset + 8),
+		configLen: t.u32(offset + 16),
+	}
+	numVrings := uint32(t.data[offset+21])
+	if uint64(rscVdevSize)+uint64(numVrings)*rscVdevVringSize+uint64(rsc.configLen) > uint64(avail) {
+		return fwRscVdev{}, fmt.Errorf("vdev rsc is truncated: %w", syscall.EINVAL)
+	}
+	if t.data[offset+22] != 0 || t.data[offset+23] != 0 {
+		return fwRscVdev{}, fmt.Errorf("vdev rsc has non zero reserved bytes: %w", syscall.EINVAL)
+	}
+	if numVrings > maxVdevVrings {
+		return fwRscVdev{}, fmt.Errorf("too many vrings: %d: %w", numVrings, syscall.EINVAL)
+	}
+	for i := range numVrings {
+		vringOffset := offset + rscVdevSize + i*rscVdevVringSize
+		vring := fwRscVdevVring{
+			offset: vringOffset,
+			da:     t.u32(vringOffset),
+			align:  t.u32(vringOffset + 4),
+			num:    t.u32(vringOffset + 8),
+		}
+		if vring.num == 0 || vring.align == 0 {
+			return fwRscVdev{}, fmt.Errorf("invalid qsz (%d) or alignment (%d): %w", vring.num, vring.align, syscall.EINVAL)
+		}
+		rsc.vrings = append(rsc.vrings, vring)
+	}
+	return rsc, nil
+}
@@ -0,0 +1,278 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// FaultKind is what a [Fault] does to the remote processor.
+type FaultKind string
+
+const (
+	// FaultCrash crashes the running remote processor with CrashFatalError
+	FaultCrash FaultKind = "crash"
+	// FaultWatchdog crashes the running remote processor with CrashWatchdog,
+	// as an expired watchdog does
+	FaultWatchdog FaultKind = "watchdog"
+	// FaultBootFailure fails the boot in progress, or else the next one once
+	// its firmware is loaded
+	FaultBootFailure FaultKind = "boot-failure"
+	// FaultStopHang makes the next stop hang: it is refused with ETIMEDOUT
+	// and the remote processor keeps its state
+	FaultStopHang FaultKind = "stop-hang"
+	// FaultFirmwareMissing makes the firmware file vanish for the next boot
+	FaultFirmwareMissing FaultKind = "firmware-missing"
+	// FaultSlowBoot makes the next boot take Delay longer
+	FaultSlowBoot FaultKind = "slow-boot"
+	// FaultRecover recovers the crashed remote processor, as writing
+	// "recover" to the recovery file does: it boots its firmware again, and
+	// stays crashed if that fails
+	FaultRecover FaultKind = "recover"
+)
+
+func (k FaultKind) validate() error {
+	switch k {
+	case FaultCrash, FaultWatchdog, FaultBootFailure, FaultStopHang, FaultFirmwareMissing, FaultSlowBoot, FaultRecover:
+		return nil
+	default:
+		return fmt.Errorf("unknown fault %q", k)
+	}
+}
+
+// Fault is an entry of a fault-injection timeline.
+type Fault struct {
+	Kind FaultKind
+	// On makes the fault relative to an event: the delay starts each time
+	// the remote processor enters this state, and the fault is cancelled if
+	// it leaves the state first. Without it, the delay starts when the fault
+	// is scheduled.
+	On string
+	// After is the delay before the fault fires
+	After time.Duration
+	// Count is how many times a fault relative to an event fires, defaults
+	// to once. Negative values fire it every time.
+	Count int
+	// Delay is how much longer a slow boot takes
+	Delay time.Duration
+}
+
+func (f Fault) validate() error {
+	if err := f.Kind.validate(); err != nil {
+		return err
+	}
+	if f.On != "" {
+		if _, ok := stateByName(f.On); !ok {
+			return fmt.Errorf("fault %s: unknown state %q", f.Kind, f.On)
+		}
+	}
+	if f.After < 0 || f.Delay < 0 {
+		return fmt.Errorf("fault %s: delay must not be negative", f.Kind)
+	}
+	return nil
+}
+
+func (f Fault) String() string {
+	kind := string(f.Kind)
+	if f.Kind == FaultSlowBoot {
+		kind = fmt.Sprintf("%s by %s", f.Kind, f.Delay)
+	}
+	if f.On == "" {
+		return fmt.Sprintf("%s after %s", kind, f.After)
+	}
+	return fmt.Sprintf("%s %s after %s", kind, f.After, f.On)
+}
+
+// FaultRecord is a fault that fired.
+type FaultRecord struct {
+	Fault Fault
+	// At is when the fault fired, relative to the creation of the
+	// remoteproc
+	At time.Duration
+	// State is the state the fault found the remote processor in
+	State State
+}
+
+type scheduledFault struct {
+	Fault
+	fired int
+	timer *loopTimer
+	id    int
+}
+
+func (f *scheduledFault) exhausted() bool {
+	count := f.Count
+	if count == 0 {
+		count = 1
+	}
+	return count > 0 && f.fired >= count
+}
+
+// faultSchedule is the fault-injection timeline of a remoteproc. Its fields
+// are owned by the remoteproc loop, except records which r.mu guards.
+type faultSchedule struct {
+	start   time.Time
+	faults  []*scheduledFault
+	records []FaultRecord
+
+	// Faults that take effect on the next boot or stop.
+	failNextBoot    bool
+	hangNextStop    bool
+	firmwareMissing bool
+	slowBootDelay   time.Duration
+}
+
+// ScheduleFault adds a fault to the timeline of the remote processor.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) ScheduleFault(fault Fault) error {
+	if err := fault.validate(); err != nil {
+		return err
+	}
+	return r.send(func() error {
+		r.scheduleFault(fault)
+		return nil
+	})
+}
+
+// FaultLog returns the faults that fired so far, in order.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) FaultLog() []FaultRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.faults.records)
+}
+
+func (r *Remoteproc) scheduleFault(fault Fault) {
+	f := &scheduledFault{Fault: fault}
+	r.faults.faults = append(r.faults.faults, f)
+	log.Printf("Fault scheduled for %s: %s", r.name, fault)
+	if fault.On == "" || fault.On == r.state.String() {
+		r.armFault(f)
+	}
+}
+
+func (r *Remoteproc) armFault(f *scheduledFault) {
+	id := f.id
+	f.timer = r.afterFunc(f.After, func() {
+		if id != f.id {
+			return
+		}
+		f.timer = nil
+		f.id++
+		f.fired++
+		r.injectFault(f.Fault)
+	})
+}
+
+func (r *Remoteproc) disarmFault(f *scheduledFault) {
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	f.id++
+}
+
+// updateFaults starts the delay of the faults relative to entering state, and
+// cancels those waiting on a state the remote processor left.
+func (r *Remoteproc) updateFaults(previous, state State) {
+	if previous == state {
+		return
+	}
+	for _, f := range r.faults.faults {
+		switch {
+		case f.On == "":
+		case f.On != state.String():
+			r.disarmFault(f)
+		case f.timer == nil && !f.exhausted():
+			r.armFault(f)
+		}
+	}
+}
+
+func (r *Remoteproc) cancelFaults() {
+	for _, f := range r.faults.faults {
+		r.disarmFault(f)
+	}
+}
+
+func (r *Remoteproc) injectFault(fault Fault) {
+	log.Printf("Injecting fault %s into %s in state %s", fault, r.name, r.state)
+	r.mu.Lock()
+	r.faults.records = append(r.faults.records, FaultRecord{
+		Fault: fault,
+		At:    time.Since(r.faults.start),
+		State: r.state,
+	})
+	r.mu.Unlock()
+
+	switch fault.Kind {
+	case FaultCrash, FaultWatchdog:
+		if r.state != StateRunning && r.state != StateSuspended && r.state != StateAttached {
+			log.Printf("Fault %s has no effect on a remote processor that is not running", fault.Kind)
+			return
+		}
+		reason := CrashFatalError
+		if fault.Kind == FaultWatchdog {
+			reason = CrashWatchdog
+		}
+		r.reportCrash(reason)
+	case FaultBootFailure:
+		if r.state == StateBooting {
+			r.kmsg(kmsgErr, "can't start rproc %s: %d", r.name, -int(syscall.EIO))
+			r.failBoot(errors.New("boot failure injected"))
+			return
+		}
+		r.faults.failNextBoot = true
+	case FaultStopHang:
+		r.faults.hangNextStop = true
+	case FaultFirmwareMissing:
+		r.faults.firmwareMissing = true
+	case FaultSlowBoot:
+		r.faults.slowBootDelay += fault.Delay
+	case FaultRecover:
+		if r.state != StateCrashed {
+			log.Printf("Fault %s has no effect on a remote processor that is not crashed", fault.Kind)
+			return
+		}
+		r.recover()
+	}
+}
+
+// loadFirmware loads the selected firmware, unless a fault made it vanish.
+func (r *Remoteproc) loadFirmware() (firmwareImage, error) {
+	if r.faults.firmwareMissing {
+		r.faults.firmwareMissing = false
+		return firmwareImage{}, fmt.Errorf("firmware file %s not found: vanished by fault injection", r.firmware)
+	}
+	return r.fs.LoadFirmware(r.firmware)
+}
+
+// stopHangs tells whether a fault makes this stop hang.
+func (r *Remoteproc) stopHangs() error {
+	if !r.faults.hangNextStop {
+		return nil
+	}
+	r.faults.hangNextStop = false
+	log.Printf("Stopping remoteproc hangs")
+	return fmt.Errorf("remoteproc stop hung: %w", syscall.ETIMEDOUT)
+}
+
+// nextBootDelay returns how long the boot starting takes, slowed down by faults.
+func (r *Remoteproc) nextBootDelay() time.Duration {
+	delay := r.bootDelay + r.faults.slowBootDelay
+	if r.faults.slowBootDelay > 0 {
+		log.Printf("Booting slowed down by %s", r.faults.slowBootDelay)
+		r.faults.slowBootDelay = 0
+	}
+	return delay
+}
+
+// bootFails tells whether a fault fails the boot completing.
+func (r *Remoteproc) bootFails() bool {
+	failed := r.faults.failNextBoot
+	r.faults.failNextBoot = false
+	return failed
+}
@@ -0,0 +1,131 @@
+package simulator_test
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaults(t *testing.T) {
+	const delay = 20 * time.Millisecond
+
+	t.Run("it crashes the remote processor some time after it runs", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: time.Millisecond, Faults: []simulator.Fault{{Kind: simulator.FaultCrash, On: "running", After: delay}}})
+
+		requireRunning(t, r)
+
+		requireState(t, r, simulator.StateCrashed)
+		assert.Equal(t, simulator.CrashFatalError, r.CrashReason())
+		faults := r.FaultLog()
+		require.Len(t, faults, 1)
+		assert.Equal(t, simulator.FaultCrash, faults[0].Fault.Kind)
+		assert.Equal(t, simulator.StateRunning, faults[0].State)
+	})
+
+	t.Run("it fires a fault relative to an event once by default", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: time.Millisecond, Faults: []simulator.Fault{{Kind: simulator.FaultCrash, On: "running", After: delay}}})
+		requireRunning(t, r)
+		requireState(t, r, simulator.StateCrashed)
+
+		requireRunning(t, r)
+		time.Sleep(3 * delay)
+
+		assert.Equal(t, simulator.StateRunning, r.State())
+		assert.Len(t, r.FaultLog(), 1)
+	})
+
+	t.Run("it cancels a fault when the remote processor leaves its state", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: time.Millisecond, Faults: []simulator.Fault{{Kind: simulator.FaultCrash, On: "running", After: 2 * delay}}})
+		requireRunning(t, r)
+
+		require.NoError(t, r.Stop())
+		time.Sleep(3 * delay)
+
+		assert.Equal(t, simulator.StateOffline, r.State())
+		assert.Empty(t, r.FaultLog())
+	})
+
+	t.Run("it expresses a crash followed by a recovery failing once", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: time.Millisecond, Faults: []simulator.Fault{
+			{Kind: simulator.FaultCrash, On: "running", After: delay},
+			{Kind: simulator.FaultBootFailure, On: "crashed"},
+			{Kind: simulator.FaultRecover, On: "crashed", After: delay, Count: 2},
+		}})
+		requireRunning(t, r)
+
+		require.Eventually(t, func() bool { return len(r.FaultLog()) == 4 }, time.Second, time.Millisecond)
+		requireState(t, r, simulator.StateRunning)
+		kinds := []simulator.FaultKind{}
+		for _, record := range r.FaultLog() {
+			kinds = append(kinds, record.Fault.Kind)
+		}
+		assert.Equal(t, []simulator.FaultKind{simulator.FaultCrash, simulator.FaultBootFailure, simulator.FaultRecover, simulator.FaultRecover}, kinds)
+		snapshot, err := r.Snapshot()
+		require.NoError(t, err)
+		assert.Equal(t, simulator.Counters{Boots: 2, Crashes: 1}, snapshot.Counters)
+	})
+
+	t.Run("it recovers only a crashed remote processor", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: time.Millisecond})
+		requireRunning(t, r)
+
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultRecover}))
+		require.Eventually(t, func() bool { return len(r.FaultLog()) == 1 }, time.Second, time.Millisecond)
+
+		assert.Equal(t, simulator.StateRunning, r.State())
+		assert.Equal(t, simulator.Counters{Boots: 1}, r.Counters())
+	})
+
+	t.Run("it fires faults relative to the timeline start", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: time.Millisecond, Faults: []simulator.Fault{{Kind: simulator.FaultWatchdog, After: 10 * delay}}})
+		requireRunning(t, r)
+
+		requireState(t, r, simulator.StateCrashed)
+		assert.Equal(t, simulator.CrashWatchdog, r.CrashReason())
+	})
+
+	t.Run("it makes the next stop hang", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: time.Millisecond})
+		requireRunning(t, r)
+
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultStopHang}))
+		require.Eventually(t, func() bool { return len(r.FaultLog()) == 1 }, time.Second, time.Millisecond)
+
+		assert.ErrorIs(t, r.Stop(), syscall.ETIMEDOUT)
+		assert.Equal(t, simulator.StateRunning, r.State())
+		require.NoError(t, r.Stop())
+	})
+
+	t.Run("it makes the firmware file vanish for the next boot", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: time.Millisecond})
+
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultFirmwareMissing}))
+		require.Eventually(t, func() bool { return len(r.FaultLog()) == 1 }, time.Second, time.Millisecond)
+
+		assert.ErrorContains(t, r.Start(), "not found")
+		requireRunning(t, r)
+	})
+
+	t.Run("it slows the next boot down", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: time.Millisecond})
+
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultSlowBoot, Delay: 5 * delay}))
+		require.Eventually(t, func() bool { return len(r.FaultLog()) == 1 }, time.Second, time.Millisecond)
+		require.NoError(t, r.Start())
+		time.Sleep(2 * delay)
+
+		assert.Equal(t, simulator.StateBooting, r.State())
+		requireState(t, r, simulator.StateRunning)
+	})
+
+	t.Run("it refuses invalid faults", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: time.Millisecond})
+
+		assert.ErrorContains(t, r.ScheduleFault(simulator.Fault{Kind: "meltdown"}), "unknown fault")
+		assert.ErrorContains(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultCrash, On: "exploded"}), "unknown state")
+	})
+}
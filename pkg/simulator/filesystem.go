@@ -1,46 +1,84 @@
 package simulator
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type FileSystemManager struct {
+	instanceName               string
+	sysDir                     string
 	instanceDir                string
 	customFirmwareLoadPathFile string
 	defaultFirmwareDir         string
+	kernelRelease              string
+	runDir                     string
+	firmwareClassDir           string
+	devDir                     string
+	deviceTreeDir              string
+	procDir                    string
+	debugfsDir                 string
 	createdDirs                []string
+	// claimed is set once the instance directory is known to belong to this
+	// instance, created or adopted
+	claimed bool
 }
 
-func NewFileSystemManager(rootDir string, index uint) *FileSystemManager {
+func NewFileSystemManager(rootDir string, index uint, kernelRelease string) *FileSystemManager {
 	instanceName := fmt.Sprintf("remoteproc%d", index)
 	return &FileSystemManager{
+		instanceName:               instanceName,
+		devDir:                     filepath.Join(rootDir, "dev"),
+		sysDir:                     filepath.Join(rootDir, "sys"),
 		instanceDir:                filepath.Join(rootDir, "sys", "class", "remoteproc", instanceName),
 		customFirmwareLoadPathFile: filepath.Join(rootDir, "sys", "module", "firmware_class", "parameters", "path"),
 		defaultFirmwareDir:         filepath.Join(rootDir, "lib", "firmware"),
+		kernelRelease:              kernelRelease,
+		runDir:                     filepath.Join(rootDir, "run"),
+		firmwareClassDir:           filepath.Join(rootDir, "sys", "class", "firmware"),
+		deviceTreeDir:              filepath.Join(rootDir, "sys", "firmware", "devicetree", "base"),
+		procDir:                    filepath.Join(rootDir, "proc"),
+		debugfsDir:                 filepath.Join(rootDir, "sys", "kernel", "debug", "remoteproc", instanceName),
 		createdDirs:                []string{},
 	}
 }
 
+// ClaimInstanceDirectory makes sure the instance directory belongs to this
+// instance before it is bootstrapped. An existing one, left by an earlier
+// run, is refused with ErrInstanceExists unless adopt is set.
+func (fs *FileSystemManager) ClaimInstanceDirectory(adopt bool) error {
+	if fileExists(fs.instanceDir) {
+		if !adopt {
+			return fmt.Errorf("%s: %w", fs.instanceDir, ErrInstanceExists)
+		}
+		log.Printf("Adopting existing instance directory %s", fs.instanceDir)
+	}
+	fs.claimed = true
+	return nil
+}
+
 func (fs *FileSystemManager) BootstrapDirectories() error {
 	createdInstancePath, err := mkdirAll(fs.instanceDir, 0755)
 	if err != nil {
 		return fmt.Errorf("failed to create instance directory: %w", err)
 	}
-	if createdInstancePath != "" {
-		fs.createdDirs = append(fs.createdDirs, createdInstancePath)
-	}
+	fs.track(fs.instanceDir, createdInstancePath)
 
 	createdParametersDir, err := mkdirAll(filepath.Dir(fs.customFirmwareLoadPathFile), 0755)
 	if err != nil {
 		fs.Cleanup()
 		return fmt.Errorf("failed to create parameters directory: %w", err)
 	}
-	if createdParametersDir != "" {
-		fs.createdDirs = append(fs.createdDirs, createdParametersDir)
-	}
+	fs.track(filepath.Dir(fs.customFirmwareLoadPathFile), createdParametersDir)
 	if err := os.WriteFile(fs.customFirmwareLoadPathFile, []byte(""), 0644); err != nil {
 		fs.Cleanup()
 		return fmt.Errorf("failed to create empty fimware search path file; %w", err)
@@ -51,42 +89,295 @@ func (fs *FileSystemManager) BootstrapDirectories() error {
 		fs.Cleanup()
 		return fmt.Errorf("failed to create firmware directory: %w", err)
 	}
-	if createdDefaultFirmwareDir != "" {
-		fs.createdDirs = append(fs.createdDirs, createdDefaultFirmwareDir)
+	fs.track(fs.defaultFirmwareDir, createdDefaultFirmwareDir)
+
+	createdRunDir, err := mkdirAll(fs.runDir, 0755)
+	if err != nil {
+		fs.Cleanup()
+		return fmt.Errorf("failed to create run directory: %w", err)
 	}
+	fs.track(fs.runDir, createdRunDir)
 
 	return nil
 }
 
-func (fs *FileSystemManager) WriteInstanceFile(filename, content string) error {
-	path := filepath.Join(fs.instanceDir, filename)
-	err := os.WriteFile(path, []byte(content), 0644)
+// BootstrapDevDirectory creates /dev, where character devices live.
+func (fs *FileSystemManager) BootstrapDevDirectory() error {
+	createdDevDir, err := mkdirAll(fs.devDir, 0755)
 	if err != nil {
-		return fmt.Errorf("failed to write %s: %w", filename, err)
+		return fmt.Errorf("failed to create dev directory: %w", err)
 	}
+	fs.track(fs.devDir, createdDevDir)
 	return nil
 }
 
-func (fs *FileSystemManager) CheckFirmwareExists(firmwareName string) error {
-	lookupPaths := []string{}
+// BootstrapFirmwareFallback creates /sys/class/firmware along with its
+// timeout file, unless another instance sharing the root already did.
+func (fs *FileSystemManager) BootstrapFirmwareFallback(timeout time.Duration) error {
+	createdFirmwareClassDir, err := mkdirAll(fs.firmwareClassDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create firmware class directory: %w", err)
+	}
+	fs.track(fs.firmwareClassDir, createdFirmwareClassDir)
+
+	timeoutFile := filepath.Join(fs.firmwareClassDir, "timeout")
+	if fileExists(timeoutFile) {
+		return nil
+	}
+	seconds := strconv.Itoa(int(timeout / time.Second))
+	if err := os.WriteFile(timeoutFile, []byte(seconds+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write firmware fallback timeout: %w", err)
+	}
+	return nil
+}
 
-	customFirmwareDir := fs.customFirmwareDir()
-	if customFirmwareDir != "" {
-		firmwareExistsInCustomPath := fileExists(filepath.Join(customFirmwareDir, firmwareName))
-		if firmwareExistsInCustomPath {
-			return nil
+// BootstrapDeviceTree creates /sys/firmware/devicetree/base with the given
+// root node properties, and /proc/device-tree linking to it, unless another
+// instance sharing the root already did.
+func (fs *FileSystemManager) BootstrapDeviceTree(rootProperties []dtProperty) error {
+	createdDeviceTreeDir, err := mkdirAll(fs.deviceTreeDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create device tree directory: %w", err)
+	}
+	fs.track(fs.deviceTreeDir, createdDeviceTreeDir)
+	if createdDeviceTreeDir != "" {
+		if err := writeDeviceTreeProperties(fs.deviceTreeDir, rootProperties); err != nil {
+			return err
 		}
-		lookupPaths = append(lookupPaths, customFirmwareDir)
 	}
 
-	defaultFirmwareDir := fs.defaultFirmwareDir
-	firmwareExistsInDefaultDir := fileExists(filepath.Join(defaultFirmwareDir, firmwareName))
-	if firmwareExistsInDefaultDir {
+	createdProcDir, err := mkdirAll(fs.procDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create proc directory: %w", err)
+	}
+	fs.track(fs.procDir, createdProcDir)
+	link := filepath.Join(fs.procDir, "device-tree")
+	if _, err := os.Lstat(link); err == nil {
+		fs.track(link, "")
 		return nil
 	}
-	lookupPaths = append(lookupPaths, defaultFirmwareDir)
+	if err := fs.symlink(fs.deviceTreeDir, link); err != nil {
+		return fmt.Errorf("failed to link /proc/device-tree: %w", err)
+	}
+	return nil
+}
+
+// WriteDeviceTreeNode writes the properties of a node, nodePath being
+// relative to /sys/firmware/devicetree/base. Properties are files holding
+// their binary value.
+func (fs *FileSystemManager) WriteDeviceTreeNode(nodePath string, properties []dtProperty) error {
+	dir := filepath.Join(fs.deviceTreeDir, filepath.FromSlash(nodePath))
+	createdNodeDir, err := mkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create device tree node %s: %w", nodePath, err)
+	}
+	fs.track(dir, createdNodeDir)
+	return writeDeviceTreeProperties(dir, properties)
+}
+
+// LinkOfNode links the instance's of_node to a device tree node.
+func (fs *FileSystemManager) LinkOfNode(nodePath string) error {
+	link := filepath.Join(fs.instanceDir, "of_node")
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale of_node: %w", err)
+	}
+	if err := fs.symlink(filepath.Join(fs.deviceTreeDir, filepath.FromSlash(nodePath)), link); err != nil {
+		return fmt.Errorf("failed to link of_node: %w", err)
+	}
+	return nil
+}
+
+// symlink creates a relative link, so that the tree stays valid when the
+// root is moved or mounted elsewhere, and removes it on Cleanup.
+func (fs *FileSystemManager) symlink(target, link string) error {
+	rel, err := filepath.Rel(filepath.Dir(link), target)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(rel, link); err != nil {
+		return err
+	}
+	fs.track(link, link)
+	return nil
+}
+
+func writeDeviceTreeProperties(dir string, properties []dtProperty) error {
+	for _, property := range properties {
+		if err := os.WriteFile(filepath.Join(dir, property.name), property.value, 0644); err != nil {
+			return fmt.Errorf("failed to write device tree property %s: %w", property.name, err)
+		}
+	}
+	return nil
+}
+
+// BootstrapDebugfs creates /sys/kernel/debug/remoteproc/remoteprocN.
+func (fs *FileSystemManager) BootstrapDebugfs() error {
+	createdDebugfsDir, err := mkdirAll(fs.debugfsDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create debugfs directory: %w", err)
+	}
+	fs.track(fs.debugfsDir, createdDebugfsDir)
+	return nil
+}
 
-	return fmt.Errorf("firmware file %s not found, checked in %s", firmwareName, strings.Join(lookupPaths, " and "))
+func (fs *FileSystemManager) WriteDebugfsFile(filename, content string) error {
+	if err := os.WriteFile(filepath.Join(fs.debugfsDir, filename), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write debugfs %s: %w", filename, err)
+	}
+	return nil
+}
+
+// BootstrapPowerDirectory creates the power directory of the instance.
+func (fs *FileSystemManager) BootstrapPowerDirectory() error {
+	if err := os.MkdirAll(fs.PowerDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create power directory: %w", err)
+	}
+	return nil
+}
+
+func (fs *FileSystemManager) WritePowerFile(filename, content string) error {
+	if err := os.WriteFile(filepath.Join(fs.PowerDir(), filename), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write power/%s: %w", filename, err)
+	}
+	return nil
+}
+
+// PowerDir returns the path of /sys/class/remoteproc/remoteprocN/power.
+func (fs *FileSystemManager) PowerDir() string {
+	return filepath.Join(fs.instanceDir, "power")
+}
+
+// DoorbellPath returns the path of the socket the host side of the doorbell
+// connects to.
+func (fs *FileSystemManager) DoorbellPath() string {
+	return filepath.Join(fs.runDir, fs.instanceName, "doorbell")
+}
+
+// BootstrapInstanceRunDirectory creates /run/remoteprocN.
+func (fs *FileSystemManager) BootstrapInstanceRunDirectory() error {
+	createdDir, err := mkdirAll(filepath.Join(fs.runDir, fs.instanceName), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create instance run directory: %w", err)
+	}
+	fs.track(filepath.Join(fs.runDir, fs.instanceName), createdDir)
+	return nil
+}
+
+// CreateCarveoutFile creates the zero-filled file backing a carveout, named
+// after it and its device address in /run/remoteprocN/memory.
+func (fs *FileSystemManager) CreateCarveoutFile(name string, da, size uint64) (*os.File, string, error) {
+	dir := filepath.Join(fs.runDir, fs.instanceName, "memory")
+	createdMemoryDir, err := mkdirAll(dir, 0755)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create memory directory: %w", err)
+	}
+	fs.track(dir, createdMemoryDir)
+
+	path := filepath.Join(dir, fmt.Sprintf("%s@%x", strings.ReplaceAll(name, "/", "!"), da))
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := file.Truncate(int64(size)); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, "", err
+	}
+	return file, path, nil
+}
+
+// FallbackTimeout reads /sys/class/firmware/timeout. Zero means no timeout.
+func (fs *FileSystemManager) FallbackTimeout() (time.Duration, error) {
+	content, err := os.ReadFile(filepath.Join(fs.firmwareClassDir, "timeout"))
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("invalid firmware fallback timeout %q", strings.TrimSpace(string(content)))
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// CreateFallbackLoader creates /sys/class/firmware/<name>/ with its loading
+// and data files. As in the kernel, slashes in the firmware name are replaced
+// with exclamation marks.
+func (fs *FileSystemManager) CreateFallbackLoader(firmwareName string) (string, error) {
+	dir := filepath.Join(fs.firmwareClassDir, strings.ReplaceAll(firmwareName, "/", "!"))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create fallback loader directory: %w", err)
+	}
+	for filename, content := range map[string]string{"loading": "0\n", "data": ""} {
+		if err := os.WriteFile(filepath.Join(dir, filename), []byte(content), 0644); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to create fallback loader %s file: %w", filename, err)
+		}
+	}
+	return dir, nil
+}
+
+func (fs *FileSystemManager) FallbackLoaderDevPath(loaderDir string) string {
+	return fs.devPath(loaderDir)
+}
+
+func (fs *FileSystemManager) WriteInstanceFile(filename, content string) error {
+	path := filepath.Join(fs.instanceDir, filename)
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return nil
+}
+
+// FirmwareSearchPath lists the directories searched for firmware, in the
+// order the kernel's firmware loader tries them. The custom path from
+// firmware_class.path is only included when set.
+func (fs *FileSystemManager) FirmwareSearchPath() []string {
+	searchPath := []string{}
+	if customFirmwareDir := fs.customFirmwareDir(); customFirmwareDir != "" {
+		searchPath = append(searchPath, customFirmwareDir)
+	}
+	return append(searchPath,
+		filepath.Join(fs.defaultFirmwareDir, "updates", fs.kernelRelease),
+		filepath.Join(fs.defaultFirmwareDir, "updates"),
+		filepath.Join(fs.defaultFirmwareDir, fs.kernelRelease),
+		fs.defaultFirmwareDir,
+	)
+}
+
+// LoadFirmware reads the first file named firmwareName in the firmware
+// search path. When there is none, compressed variants are tried in the order
+// of [firmwareSuffixes]. As in the kernel, a corrupt compressed file fails the
+// load rather than moving on to the next kind of compression.
+func (fs *FileSystemManager) LoadFirmware(firmwareName string) (firmwareImage, error) {
+	if err := validateFirmwareName(firmwareName); err != nil {
+		return firmwareImage{}, err
+	}
+	searchPath := fs.FirmwareSearchPath()
+	for _, variant := range firmwareSuffixes {
+		var loadErr error
+		for _, dir := range searchPath {
+			path, err := firmwareFilePath(dir, firmwareName+variant.suffix)
+			if err != nil {
+				return firmwareImage{}, err
+			}
+			if !fileExists(path) {
+				continue
+			}
+			image, err := readFirmware(firmwareName, path, variant.decompress)
+			if err == nil {
+				err = resolveFirmwareSymlinks(&image, dir)
+			}
+			if err == nil {
+				return image, nil
+			}
+			loadErr = err
+		}
+		if loadErr != nil {
+			return firmwareImage{}, loadErr
+		}
+	}
+	return firmwareImage{}, fmt.Errorf("firmware file %s not found, checked in %s", firmwareName, joinPaths(searchPath))
 }
 
 func (fs *FileSystemManager) customFirmwareDir() string {
@@ -97,20 +388,110 @@ func (fs *FileSystemManager) customFirmwareDir() string {
 	return strings.TrimSpace(string(customFirmwareLoadPath))
 }
 
+func (fs *FileSystemManager) InstanceName() string {
+	return fs.instanceName
+}
+
+// KmsgPath returns the path of /dev/kmsg, the kernel log.
+func (fs *FileSystemManager) KmsgPath() string {
+	return filepath.Join(fs.devDir, kmsgFileName)
+}
+
+// CdevPath returns the path of /dev/remoteprocN.
+func (fs *FileSystemManager) CdevPath() string {
+	return filepath.Join(fs.devDir, fs.instanceName)
+}
+
 func (fs *FileSystemManager) InstanceDir() string {
 	return fs.instanceDir
 }
 
+// DevPath returns the DEVPATH of the instance in uevents. As in the kernel,
+// it is that of the device, which /sys/class/remoteproc/remoteprocN stands
+// for: /devices/platform/remoteprocN/remoteproc/remoteprocN.
+func (fs *FileSystemManager) DevPath() string {
+	return path.Join("/devices/platform", fs.instanceName, "remoteproc", fs.instanceName)
+}
+
+func (fs *FileSystemManager) devPath(dir string) string {
+	rel, err := filepath.Rel(fs.sysDir, dir)
+	if err != nil {
+		return dir
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+func (fs *FileSystemManager) UeventLogPath() string {
+	return filepath.Join(fs.runDir, ueventLogFileName)
+}
+
+// Cleanup removes the directories the instance created. Those other
+// instances sharing the root still use, such as /sys or /lib/firmware, are
+// left to the last of them.
 func (fs *FileSystemManager) Cleanup() error {
-	for _, dir := range fs.createdDirs {
+	sharedDirs.Lock()
+	defer sharedDirs.Unlock()
+
+	var errs []error
+	for _, dir := range slices.Backward(fs.createdDirs) {
+		sharedDirs.users[dir]--
+		if sharedDirs.users[dir] > 0 {
+			continue
+		}
+		delete(sharedDirs.users, dir)
 		if err := os.RemoveAll(dir); err != nil {
-			return fmt.Errorf("failed to remove directory %s: %w", dir, err)
+			errs = append(errs, fmt.Errorf("failed to remove directory %s: %w", dir, err))
 		}
 	}
 	fs.createdDirs = []string{}
-	return nil
+	return errors.Join(errs...)
+}
+
+// track records that the instance uses path, created being the topmost
+// directory mkdirAll created for it, if any. The instance takes a reference
+// on every directory down to path that an instance created, so that none is
+// removed while another instance sharing the root still uses it.
+func (fs *FileSystemManager) track(path, created string) {
+	sharedDirs.Lock()
+	defer sharedDirs.Unlock()
+
+	if created != "" {
+		for dir := path; ; dir = filepath.Dir(dir) {
+			sharedDirs.users[dir] = 0
+			if dir == created {
+				break
+			}
+		}
+	}
+	for dir := path; dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if _, ok := sharedDirs.users[dir]; ok && !slices.Contains(fs.createdDirs, dir) {
+			sharedDirs.users[dir]++
+			fs.createdDirs = append(fs.createdDirs, dir)
+		}
+	}
 }
 
+// RemoveAll removes the directories Cleanup does, along with those of the
+// instance that existed before it was created.
+func (fs *FileSystemManager) RemoveAll() error {
+	if fs.claimed {
+		for _, path := range []string{fs.instanceDir, filepath.Join(fs.runDir, fs.instanceName), fs.debugfsDir, fs.CdevPath()} {
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+		}
+	}
+	return fs.Cleanup()
+}
+
+// sharedDirs counts the instances using each directory created by one of
+// them, as instances sharing a root directory share /sys, /lib/firmware and
+// /run.
+var sharedDirs = struct {
+	sync.Mutex
+	users map[string]int
+}{users: map[string]int{}}
+
 func mkdirAll(path string, perm os.FileMode) (string, error) {
 	current := path
 	var topmostMissing string
@@ -143,3 +524,10 @@ func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return !os.IsNotExist(err)
 }
+
+func joinPaths(paths []string) string {
+	if len(paths) == 1 {
+		return paths[0]
+	}
+	return strings.Join(paths[:len(paths)-1], ", ") + " and " + paths[len(paths)-1]
+}
@@ -0,0 +1,85 @@
+package simulator_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirmwareSearchPath(t *testing.T) {
+	const release = "6.1.0-test"
+
+	tests := []struct {
+		name     string
+		present  []string
+		wantPath string
+	}{
+		{
+			name:     "it falls back to /lib/firmware",
+			present:  []string{"lib/firmware"},
+			wantPath: "lib/firmware",
+		},
+		{
+			name:     "release specific directory takes precedence over /lib/firmware",
+			present:  []string{"lib/firmware", "lib/firmware/" + release},
+			wantPath: "lib/firmware/" + release,
+		},
+		{
+			name:     "updates take precedence over the release specific directory",
+			present:  []string{"lib/firmware", "lib/firmware/" + release, "lib/firmware/updates"},
+			wantPath: "lib/firmware/updates",
+		},
+		{
+			name:     "release specific updates take precedence over everything in /lib/firmware",
+			present:  []string{"lib/firmware", "lib/firmware/" + release, "lib/firmware/updates", "lib/firmware/updates/" + release},
+			wantPath: "lib/firmware/updates/" + release,
+		},
+		{
+			name:     "custom firmware path takes precedence over everything",
+			present:  []string{"custom", "lib/firmware/updates/" + release},
+			wantPath: "custom",
+		},
+		{
+			name:     "directories of other releases are ignored",
+			present:  []string{"lib/firmware", "lib/firmware/updates/5.15.0"},
+			wantPath: "lib/firmware",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			r := newTestRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0", KernelRelease: release})
+			writeFile(t, filepath.Join(root, "sys", "module", "firmware_class", "parameters", "path"), filepath.Join(root, "custom"))
+			for _, dir := range tt.present {
+				require.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+				createFirmwareFile(t, filepath.Join(root, dir, "some-firmware.elf"))
+			}
+			selectFirmware(t, r, "some-firmware.elf")
+
+			require.NoError(t, r.Start())
+
+			requireState(t, r, simulator.StateRunning)
+			assert.Equal(t, filepath.Join(root, tt.wantPath, "some-firmware.elf"), r.FirmwarePath())
+		})
+	}
+
+	t.Run("failed lookups list every searched directory", func(t *testing.T) {
+		root := t.TempDir()
+		r := newTestRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0", KernelRelease: release})
+		selectFirmware(t, r, "some-firmware.elf")
+
+		err := r.Start()
+
+		firmwareDir := filepath.Join(root, "lib", "firmware")
+		assert.ErrorContains(t, err, "checked in "+
+			filepath.Join(firmwareDir, "updates", release)+", "+
+			filepath.Join(firmwareDir, "updates")+", "+
+			filepath.Join(firmwareDir, release)+" and "+
+			firmwareDir)
+	})
+}
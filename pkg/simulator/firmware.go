@@ -0,0 +1,148 @@
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// firmwareImage is a firmware as handed over by the firmware loader: its
+// content, decompressed if needed, along with the file it was read from.
+type firmwareImage struct {
+	name string
+	path string
+	// resolvedPath is path with all symbolic links resolved
+	resolvedPath string
+	// viaSymlink tells whether the firmware was reached through a symbolic
+	// link within the search directory it was found in
+	viaSymlink bool
+	data       []byte
+	// size is the size of the file read, which is smaller than data when it
+	// is compressed
+	size int
+}
+
+// firmwareDecompressor turns the content of a compressed firmware file into
+// the firmware image.
+type firmwareDecompressor func(compressed []byte) ([]byte, error)
+
+// firmwareSuffixes lists the variants of a firmware file the kernel tries, in
+// order, when built with CONFIG_FW_LOADER_COMPRESS. Each variant is searched
+// for in the whole search path before moving on to the next.
+var firmwareSuffixes = []struct {
+	suffix     string
+	decompress firmwareDecompressor
+}{
+	{"", nil},
+	{".zst", decompressZstd},
+	{".xz", decompressXz},
+}
+
+func decompressZstd(compressed []byte) ([]byte, error) {
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	data, err := decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ZSTD-decompression failed: %w", err)
+	}
+	return data, nil
+}
+
+func decompressXz(compressed []byte) ([]byte, error) {
+	reader, err := xz.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("xz decompression failed: %w", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("xz decompression failed: %w", err)
+	}
+	return data, nil
+}
+
+// pathMax is the kernel's PATH_MAX, which bounds the full path of a firmware
+// file, search directory included.
+const pathMax = 4096
+
+// validateFirmwareName applies the checks the kernel makes on a firmware name
+// before searching for it. A name is always looked up relative to the search
+// directories, a leading slash included, so refusing '..' components is
+// enough to keep lookups from escaping them.
+func validateFirmwareName(name string) error {
+	if name == "" || strings.ContainsRune(name, 0) {
+		return fmt.Errorf("invalid firmware name %q: %w", name, syscall.EINVAL)
+	}
+	if len(name) >= pathMax {
+		return fmt.Errorf("firmware name is %d bytes long: %w", len(name), syscall.ENAMETOOLONG)
+	}
+	if slices.Contains(strings.FieldsFunc(name, isPathSeparator), "..") {
+		return fmt.Errorf("Firmware load for '%s' refused, path contains '..' component: %w", name, syscall.EINVAL)
+	}
+	return nil
+}
+
+// firmwareFilePath joins a search directory and a firmware name the way the
+// kernel does, which is a plain concatenation bounded by PATH_MAX.
+func firmwareFilePath(dir, name string) (string, error) {
+	path := dir + "/" + name
+	if len(path) >= pathMax {
+		return "", fmt.Errorf("firmware path %s...: %w", path[:64], syscall.ENAMETOOLONG)
+	}
+	return filepath.Clean(filepath.FromSlash(path)), nil
+}
+
+func readFirmware(name, path string, decompress firmwareDecompressor) (firmwareImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return firmwareImage{}, fmt.Errorf("failed to read firmware %s: %w", path, err)
+	}
+	size := len(data)
+	if decompress != nil {
+		if data, err = decompress(data); err != nil {
+			return firmwareImage{}, fmt.Errorf("failed to decompress firmware %s: %w", path, err)
+		}
+	}
+	return firmwareImage{name: name, path: path, resolvedPath: path, data: data, size: size}, nil
+}
+
+// resolveFirmwareSymlinks records where image really comes from when it was
+// found in dir through a symbolic link, either the file itself or one of the
+// directories below dir. Links above dir, e.g. in the root directory, are not
+// considered.
+func resolveFirmwareSymlinks(image *firmwareImage, dir string) error {
+	resolvedPath, err := filepath.EvalSymlinks(image.path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve firmware %s: %w", image.path, err)
+	}
+	image.resolvedPath = resolvedPath
+
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil
+	}
+	rel, err := filepath.Rel(dir, image.path)
+	if err != nil {
+		return nil
+	}
+	image.viaSymlink = resolvedPath != filepath.Join(resolvedDir, rel)
+	return nil
+}
+
+func isPathSeparator(r rune) bool {
+	return r == '/' || r == filepath.Separator
+}
+
+func isCompressedFirmware(path string) bool {
+	return strings.HasSuffix(path, ".zst") || strings.HasSuffix(path, ".xz")
+}
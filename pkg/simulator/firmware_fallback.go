@@ -0,0 +1,154 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/internal/dirwatcher"
+)
+
+const defaultFallbackTimeout = 60 * time.Second
+
+type fallbackConfig struct {
+	enabled bool
+	timeout time.Duration
+}
+
+// fallbackLoader emulates the firmware_class sysfs fallback: while the kernel
+// waits for a missing firmware, userspace writes 1 to loading, the image to
+// data and then 0 to loading to complete the request, or -1 to abort it.
+type fallbackLoader struct {
+	firmware string
+	dir      string
+	devPath  string
+	watcher  *dirwatcher.DirWatcher
+	timer    *loopTimer
+	// loading tells that userspace started a load, so that a 0 written to
+	// loading completes it
+	loading bool
+}
+
+func (f *fallbackLoader) Changes() <-chan dirwatcher.FileChangeEvent {
+	if f == nil {
+		return nil
+	}
+	return f.watcher.Changes()
+}
+
+func (r *Remoteproc) startFallback() error {
+	timeout, err := r.fs.FallbackTimeout()
+	if err != nil {
+		return err
+	}
+
+	dir, err := r.fs.CreateFallbackLoader(r.firmware)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := dirwatcher.New(context.Background(), dir, r.watcherConfig)
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("failed to watch fallback loader: %w", err)
+	}
+
+	r.fallback = &fallbackLoader{
+		firmware: r.firmware,
+		dir:      dir,
+		devPath:  r.fs.FallbackLoaderDevPath(dir),
+		watcher:  watcher,
+	}
+	if timeout > 0 {
+		id := r.bootID
+		r.fallback.timer = r.afterFunc(timeout, func() {
+			if id != r.bootID || r.fallback == nil {
+				return
+			}
+			r.kmsg(kmsgErr, "request_firmware failed: %d", -int(syscall.ETIMEDOUT))
+			r.failBoot(fmt.Errorf("loading of firmware %s timed out after %s", r.firmware, timeout))
+		})
+	}
+
+	r.logUevent(Uevent{
+		Action:    UeventAdd,
+		DevPath:   r.fallback.devPath,
+		Subsystem: "firmware",
+		Firmware:  r.firmware,
+		Timeout:   strconv.Itoa(int(timeout / time.Second)),
+		Async:     "0",
+	})
+	log.Printf("Waiting up to %s for firmware %s to be supplied through %s", timeout, r.firmware, dir)
+	return nil
+}
+
+func (r *Remoteproc) handleFallbackChange(event dirwatcher.FileChangeEvent, ok bool) {
+	if !ok {
+		r.failBoot(errors.New("fallback loader stopped unexpectedly"))
+		return
+	}
+	// When userspace writes 1, data and 0 in quick succession, the 1 that
+	// starts a load is coalesced with the 0 that follows. The data written in
+	// between shows that the load started all the same.
+	if event.Filename == "data" {
+		r.fallback.loading = true
+		return
+	}
+	if event.Filename != "loading" {
+		return
+	}
+
+	switch event.Value {
+	case "1":
+		r.fallback.loading = true
+		log.Printf("Firmware %s loading started", r.firmware)
+	case "0":
+		if !r.fallback.loading {
+			log.Printf("Ignoring the end of a firmware %s load that did not start", r.firmware)
+			return
+		}
+		dataPath := filepath.Join(r.fallback.dir, "data")
+		data, err := os.ReadFile(dataPath)
+		if err != nil {
+			r.failBoot(fmt.Errorf("failed to read firmware supplied through sysfs: %w", err))
+			return
+		}
+		r.closeFallback()
+		log.Printf("Firmware %s supplied through sysfs fallback", r.firmware)
+		if err := r.firmwareLoaded(firmwareImage{name: r.firmware, path: dataPath, resolvedPath: dataPath, data: data, size: len(data)}); err != nil {
+			r.failBoot(err)
+		}
+	case "-1":
+		r.kmsg(kmsgErr, "request_firmware failed: %d", -int(syscall.ENOENT))
+		r.failBoot(fmt.Errorf("loading of firmware %s aborted", r.firmware))
+	default:
+		log.Printf("Invalid firmware loading command: %s", event.Value)
+	}
+}
+
+func (r *Remoteproc) closeFallback() {
+	f := r.fallback
+	if f == nil {
+		return
+	}
+	r.fallback = nil
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	f.watcher.Close()
+	if err := os.RemoveAll(f.dir); err != nil {
+		log.Printf("Failed to remove fallback loader %s: %v", f.dir, err)
+	}
+	r.logUevent(Uevent{
+		Action:    UeventRemove,
+		DevPath:   f.devPath,
+		Subsystem: "firmware",
+		Firmware:  f.firmware,
+	})
+}
@@ -0,0 +1,128 @@
+package simulator_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirmwareFallback(t *testing.T) {
+	t.Run("a missing firmware can be supplied through sysfs", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{FirmwareFallback: true})
+		loaderDir := filepath.Join(root, "sys", "class", "firmware", "missing.elf")
+		selectFirmware(t, r, "missing.elf")
+
+		require.NoError(t, r.Start())
+
+		assert.Equal(t, simulator.StateBooting, r.State())
+		assertFileContent(t, filepath.Join(loaderDir, "loading"), "0\n")
+		writeFile(t, filepath.Join(loaderDir, "loading"), "1")
+		writeFile(t, filepath.Join(loaderDir, "data"), "\x7fELF")
+		writeFile(t, filepath.Join(loaderDir, "loading"), "0")
+
+		requireState(t, r, simulator.StateRunning)
+		assert.NoDirExists(t, loaderDir)
+		assert.Equal(t, filepath.Join(loaderDir, "data"), r.FirmwarePath())
+	})
+
+	t.Run("a load that did not start is not completed", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{FirmwareFallback: true})
+		loaderDir := filepath.Join(root, "sys", "class", "firmware", "missing.elf")
+		selectFirmware(t, r, "missing.elf")
+		require.NoError(t, r.Start())
+
+		writeFile(t, filepath.Join(loaderDir, "loading"), "0")
+
+		assert.Never(t, func() bool { return r.State() != simulator.StateBooting }, 100*time.Millisecond, 5*time.Millisecond)
+		assert.DirExists(t, loaderDir)
+	})
+
+	t.Run("a firmware request is announced with a uevent", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{FirmwareFallback: true})
+		selectFirmware(t, r, "missing.elf")
+
+		require.NoError(t, r.Start())
+
+		events := readUevents(t, root)
+		require.Len(t, events, 2)
+		assert.Equal(t, simulator.Uevent{
+			Action:    simulator.UeventAdd,
+			DevPath:   "/class/firmware/missing.elf",
+			Subsystem: "firmware",
+			Firmware:  "missing.elf",
+			Timeout:   "60",
+			Async:     "0",
+			SeqNum:    "2",
+		}, events[1])
+	})
+
+	t.Run("slashes in the firmware name are replaced in the loader directory", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{FirmwareFallback: true})
+		selectFirmware(t, r, "vendor/missing.elf")
+
+		require.NoError(t, r.Start())
+
+		assert.DirExists(t, filepath.Join(root, "sys", "class", "firmware", "vendor!missing.elf"))
+	})
+
+	t.Run("aborting the load fails the boot", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{FirmwareFallback: true})
+		loaderDir := filepath.Join(root, "sys", "class", "firmware", "missing.elf")
+		selectFirmware(t, r, "missing.elf")
+		require.NoError(t, r.Start())
+
+		writeFile(t, filepath.Join(loaderDir, "loading"), "-1")
+
+		requireState(t, r, simulator.StateOffline)
+		assert.NoDirExists(t, loaderDir)
+	})
+
+	t.Run("the boot fails when nothing is supplied within the timeout", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{FirmwareFallback: true})
+		loaderDir := filepath.Join(root, "sys", "class", "firmware", "missing.elf")
+		writeFile(t, filepath.Join(root, "sys", "class", "firmware", "timeout"), "1")
+		selectFirmware(t, r, "missing.elf")
+
+		require.NoError(t, r.Start())
+
+		time.Sleep(500 * time.Millisecond)
+		assert.Equal(t, simulator.StateBooting, r.State())
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			assert.Equal(c, simulator.StateOffline, r.State())
+		}, 2*time.Second, 10*time.Millisecond)
+		assert.NoDirExists(t, loaderDir)
+	})
+
+	t.Run("stop cancels the pending request", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{FirmwareFallback: true})
+		loaderDir := filepath.Join(root, "sys", "class", "firmware", "missing.elf")
+		selectFirmware(t, r, "missing.elf")
+		require.NoError(t, r.Start())
+
+		require.NoError(t, r.Stop())
+
+		assert.Equal(t, simulator.StateOffline, r.State())
+		assert.NoDirExists(t, loaderDir)
+	})
+
+	t.Run("firmware found on disk does not use the fallback", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{FirmwareFallback: true})
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+		selectFirmware(t, r, "some-firmware.elf")
+
+		require.NoError(t, r.Start())
+
+		requireState(t, r, simulator.StateRunning)
+		assert.NoDirExists(t, filepath.Join(root, "sys", "class", "firmware", "some-firmware.elf"))
+	})
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
@@ -0,0 +1,249 @@
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+func TestLoadFirmware(t *testing.T) {
+	const release = "6.1.0-test"
+	image := []byte("\x7fELF firmware image")
+
+	newFileSystem := func(t *testing.T) (*FileSystemManager, string) {
+		root := t.TempDir()
+		fs := NewFileSystemManager(root, 0, release)
+		require.NoError(t, fs.BootstrapDirectories())
+		return fs, filepath.Join(root, "lib", "firmware")
+	}
+
+	t.Run("it decompresses zstd firmware", func(t *testing.T) {
+		fs, firmwareDir := newFileSystem(t)
+		writeFirmware(t, filepath.Join(firmwareDir, "dsp.elf.zst"), compressZstd(t, image))
+
+		got, err := fs.LoadFirmware("dsp.elf")
+
+		require.NoError(t, err)
+		assert.Equal(t, image, got.data)
+		assert.Equal(t, filepath.Join(firmwareDir, "dsp.elf.zst"), got.path)
+	})
+
+	t.Run("it decompresses xz firmware", func(t *testing.T) {
+		fs, firmwareDir := newFileSystem(t)
+		writeFirmware(t, filepath.Join(firmwareDir, "dsp.elf.xz"), compressXz(t, image))
+
+		got, err := fs.LoadFirmware("dsp.elf")
+
+		require.NoError(t, err)
+		assert.Equal(t, image, got.data)
+		assert.Equal(t, filepath.Join(firmwareDir, "dsp.elf.xz"), got.path)
+	})
+
+	t.Run("uncompressed firmware anywhere in the search path takes precedence", func(t *testing.T) {
+		fs, firmwareDir := newFileSystem(t)
+		writeFirmware(t, filepath.Join(firmwareDir, "updates", "dsp.elf.zst"), compressZstd(t, []byte("compressed")))
+		writeFirmware(t, filepath.Join(firmwareDir, "dsp.elf"), image)
+
+		got, err := fs.LoadFirmware("dsp.elf")
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(firmwareDir, "dsp.elf"), got.path)
+	})
+
+	t.Run("zstd takes precedence over xz", func(t *testing.T) {
+		fs, firmwareDir := newFileSystem(t)
+		writeFirmware(t, filepath.Join(firmwareDir, "updates", "dsp.elf.xz"), compressXz(t, image))
+		writeFirmware(t, filepath.Join(firmwareDir, "dsp.elf.zst"), compressZstd(t, image))
+
+		got, err := fs.LoadFirmware("dsp.elf")
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(firmwareDir, "dsp.elf.zst"), got.path)
+	})
+
+	t.Run("a later directory is tried when decompression fails", func(t *testing.T) {
+		fs, firmwareDir := newFileSystem(t)
+		writeFirmware(t, filepath.Join(firmwareDir, "updates", "dsp.elf.zst"), []byte("corrupt"))
+		writeFirmware(t, filepath.Join(firmwareDir, "dsp.elf.zst"), compressZstd(t, image))
+
+		got, err := fs.LoadFirmware("dsp.elf")
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(firmwareDir, "dsp.elf.zst"), got.path)
+	})
+
+	t.Run("a corrupt archive fails the load without trying other compressions", func(t *testing.T) {
+		fs, firmwareDir := newFileSystem(t)
+		writeFirmware(t, filepath.Join(firmwareDir, "dsp.elf.zst"), []byte("corrupt"))
+		writeFirmware(t, filepath.Join(firmwareDir, "dsp.elf.xz"), compressXz(t, image))
+
+		_, err := fs.LoadFirmware("dsp.elf")
+
+		assert.ErrorContains(t, err, "failed to decompress firmware")
+	})
+
+	t.Run("a truncated xz archive fails the load", func(t *testing.T) {
+		fs, firmwareDir := newFileSystem(t)
+		compressed := compressXz(t, image)
+		writeFirmware(t, filepath.Join(firmwareDir, "dsp.elf.xz"), compressed[:len(compressed)/2])
+
+		_, err := fs.LoadFirmware("dsp.elf")
+
+		assert.ErrorContains(t, err, "xz decompression failed")
+	})
+}
+
+func writeFirmware(t *testing.T, path string, content []byte) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, content, 0644))
+}
+
+func compressZstd(t *testing.T, data []byte) []byte {
+	t.Helper()
+	encoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	defer encoder.Close()
+	return encoder.EncodeAll(data, nil)
+}
+
+func compressXz(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestValidateFirmwareName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr error
+	}{
+		{name: "fw.elf"},
+		{name: "vendor/board/fw.elf"},
+		{name: "/fw.elf"},
+		{name: "fw..elf"},
+		{name: "..fw.elf"},
+		{name: "vendor/.../fw.elf"},
+		{name: "", wantErr: syscall.EINVAL},
+		{name: "fw\x00.elf", wantErr: syscall.EINVAL},
+		{name: "..", wantErr: syscall.EINVAL},
+		{name: "../fw.elf", wantErr: syscall.EINVAL},
+		{name: "vendor/..", wantErr: syscall.EINVAL},
+		{name: "../../etc/passwd", wantErr: syscall.EINVAL},
+		{name: "vendor/../../fw.elf", wantErr: syscall.EINVAL},
+		{name: "vendor//..//fw.elf", wantErr: syscall.EINVAL},
+		{name: strings.Repeat("a", pathMax), wantErr: syscall.ENAMETOOLONG},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%.40q", tt.name), func(t *testing.T) {
+			err := validateFirmwareName(tt.name)
+
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func FuzzValidateFirmwareName(f *testing.F) {
+	for _, seed := range []string{"fw.elf", "vendor/fw.elf", "/etc/passwd", "../fw.elf", "a/../../b", "..", "a/./b", `..\fw.elf`} {
+		f.Add(seed)
+	}
+	dir := filepath.Join(f.TempDir(), "lib", "firmware")
+
+	f.Fuzz(func(t *testing.T, name string) {
+		if validateFirmwareName(name) != nil {
+			return
+		}
+		path, err := firmwareFilePath(dir, name)
+		if err != nil {
+			return
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			t.Errorf("firmware name %q escapes the search directory: %s", name, path)
+		}
+	})
+}
+
+func TestLoadFirmwareNames(t *testing.T) {
+	newFileSystem := func(t *testing.T) (*FileSystemManager, string) {
+		root := t.TempDir()
+		fs := NewFileSystemManager(root, 0, "6.1.0-test")
+		require.NoError(t, fs.BootstrapDirectories())
+		return fs, root
+	}
+
+	t.Run("absolute names are looked up inside the search directories", func(t *testing.T) {
+		fs, root := newFileSystem(t)
+		writeFirmware(t, filepath.Join(root, "etc", "passwd"), []byte("root"))
+		writeFirmware(t, filepath.Join(root, "lib", "firmware", "etc", "passwd"), []byte("firmware"))
+
+		got, err := fs.LoadFirmware("/etc/passwd")
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(root, "lib", "firmware", "etc", "passwd"), got.path)
+	})
+
+	t.Run("path traversal is refused", func(t *testing.T) {
+		fs, root := newFileSystem(t)
+		writeFirmware(t, filepath.Join(root, "etc", "passwd"), []byte("root"))
+
+		_, err := fs.LoadFirmware("../../etc/passwd")
+
+		assert.ErrorIs(t, err, syscall.EINVAL)
+		assert.ErrorContains(t, err, "Firmware load for '../../etc/passwd' refused, path contains '..' component")
+	})
+
+	t.Run("paths longer than PATH_MAX are refused", func(t *testing.T) {
+		fs, _ := newFileSystem(t)
+
+		_, err := fs.LoadFirmware(strings.Repeat("a/", pathMax/2-8) + "fw.elf")
+
+		assert.ErrorIs(t, err, syscall.ENAMETOOLONG)
+	})
+
+	t.Run("symbolic links are followed and reported", func(t *testing.T) {
+		fs, root := newFileSystem(t)
+		target := filepath.Join(root, "opt", "fw-v2.elf")
+		writeFirmware(t, target, []byte("v2"))
+		require.NoError(t, os.Symlink(target, filepath.Join(root, "lib", "firmware", "fw.elf")))
+
+		got, err := fs.LoadFirmware("fw.elf")
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(root, "lib", "firmware", "fw.elf"), got.path)
+		wantResolvedPath, err := filepath.EvalSymlinks(target)
+		require.NoError(t, err)
+		assert.Equal(t, wantResolvedPath, got.resolvedPath)
+		assert.True(t, got.viaSymlink)
+		assert.Equal(t, []byte("v2"), got.data)
+	})
+
+	t.Run("regular files are not reported as symbolic links", func(t *testing.T) {
+		fs, root := newFileSystem(t)
+		writeFirmware(t, filepath.Join(root, "lib", "firmware", "fw.elf"), []byte("v1"))
+
+		got, err := fs.LoadFirmware("fw.elf")
+
+		require.NoError(t, err)
+		assert.False(t, got.viaSymlink)
+	})
+}
@@ -0,0 +1,122 @@
+package simulator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	kmsgFileName = "kmsg"
+	// kmsgMaxSize is the size past which the kernel log rolls over to
+	// kmsg.1, as a ring buffer drops its oldest records
+	kmsgMaxSize = 1 << 20
+)
+
+// Log levels of kernel messages, as in include/linux/kern_levels.h.
+const (
+	kmsgErr  = 3
+	kmsgWarn = 4
+	kmsgInfo = 6
+)
+
+// kmsgBoot is when the emulated kernel booted, which kernel log timestamps
+// are relative to.
+var kmsgBoot = time.Now()
+
+// kmsgSeqNums holds the last sequence number handed out per kernel log. Like
+// the kernel's, the counter is shared by all devices.
+var kmsgSeqNums = struct {
+	sync.Mutex
+	last map[string]uint64
+}{last: map[string]uint64{}}
+
+// appendKmsg appends a message of a remoteproc device to the kernel log at
+// path, in the format /dev/kmsg is read in:
+//
+//	6,42,1234567,-;remoteproc remoteproc0: powering up dsp0
+//	 SUBSYSTEM=remoteproc
+//	 DEVICE=+remoteproc:remoteproc0
+func appendKmsg(path string, level int, device, message string) error {
+	kmsgSeqNums.Lock()
+	defer kmsgSeqNums.Unlock()
+
+	last, ok := kmsgSeqNums.last[path]
+	if !ok {
+		last = lastKmsgSeqNum(path)
+	}
+	record := fmt.Sprintf("%d,%d,%d,-;%s %s: %s\n SUBSYSTEM=%s\n DEVICE=+%s:%s\n",
+		level, last+1, time.Since(kmsgBoot).Microseconds(), ueventSubsystem, device, message,
+		ueventSubsystem, ueventSubsystem, device)
+
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(record)) > kmsgMaxSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return fmt.Errorf("failed to roll kernel log over: %w", err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open kernel log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(record); err != nil {
+		return fmt.Errorf("failed to write kernel log: %w", err)
+	}
+
+	kmsgSeqNums.last[path] = last + 1
+	return nil
+}
+
+// lastKmsgSeqNum continues the sequence of a log left behind by an earlier run,
+// which may have just rolled over to kmsg.1.
+func lastKmsgSeqNum(path string) uint64 {
+	return max(lastSeqNumIn(path+".1"), lastSeqNumIn(path))
+}
+
+// lastSeqNumIn returns the highest sequence number in the kernel log at path.
+func lastSeqNumIn(path string) uint64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var last uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ",", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		if seqNum, err := strconv.ParseUint(fields[1], 10, 64); err == nil && seqNum > last {
+			last = seqNum
+		}
+	}
+	return last
+}
+
+// kmsg logs a message of the remote processor to the kernel log, as dev_info
+// and dev_err do for the rproc device.
+func (r *Remoteproc) kmsg(level int, format string, args ...any) {
+	err := appendKmsg(r.fs.KmsgPath(), level, r.fs.InstanceName(), fmt.Sprintf(format, args...))
+	if err != nil {
+		log.Printf("Failed to write kernel log: %s", err)
+	}
+}
+
+// kernelErrno returns the negative errno the kernel reports for err, or
+// fallback when err does not carry one.
+func kernelErrno(err error, fallback syscall.Errno) int {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return -int(errno)
+	}
+	return -int(fallback)
+}
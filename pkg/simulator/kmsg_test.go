@@ -0,0 +1,176 @@
+package simulator_test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKmsg(t *testing.T) {
+	kmsgConfig := simulator.Config{BootDelay: time.Millisecond}
+
+	t.Run("it logs a boot and a stop as the remoteproc core does", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, kmsgConfig)
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+		selectFirmware(t, r, "some-firmware.elf")
+
+		require.NoError(t, r.Start())
+		requireState(t, r, simulator.StateRunning)
+		require.NoError(t, r.Stop())
+		requireState(t, r, simulator.StateOffline)
+
+		assert.Equal(t, []string{
+			"6;remoteproc remoteproc0: powering up dsp0",
+			"6;remoteproc remoteproc0: Booting fw image some-firmware.elf, size 0",
+			"6;remoteproc remoteproc0: remote processor dsp0 is now up",
+			"6;remoteproc remoteproc0: stopped remote processor dsp0",
+		}, readKmsg(t, root))
+	})
+
+	t.Run("it writes records in the format /dev/kmsg is read in", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, kmsgConfig)
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+		selectFirmware(t, r, "some-firmware.elf")
+
+		require.NoError(t, r.Start())
+		requireState(t, r, simulator.StateRunning)
+
+		content, err := os.ReadFile(filepath.Join(root, "dev", "kmsg"))
+		require.NoError(t, err)
+		assert.Regexp(t, regexp.MustCompile(`^6,1,\d+,-;remoteproc remoteproc0: powering up dsp0\n`+
+			` SUBSYSTEM=remoteproc\n DEVICE=\+remoteproc:remoteproc0\n6,2,\d+,-;`), string(content))
+	})
+
+	t.Run("it logs a missing firmware", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, kmsgConfig)
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+		selectFirmware(t, r, "some-firmware.elf")
+		require.NoError(t, os.Remove(filepath.Join(root, "lib", "firmware", "some-firmware.elf")))
+
+		require.Error(t, r.Start())
+
+		assert.Equal(t, []string{
+			"6;remoteproc remoteproc0: powering up dsp0",
+			"4;remoteproc remoteproc0: Direct firmware load for some-firmware.elf failed with error -2",
+			"3;remoteproc remoteproc0: request_firmware failed: -2",
+		}, readKmsg(t, root))
+	})
+
+	t.Run("it logs a crash", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, kmsgConfig)
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+		selectFirmware(t, r, "some-firmware.elf")
+		require.NoError(t, r.Start())
+		requireState(t, r, simulator.StateRunning)
+
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultWatchdog}))
+
+		requireState(t, r, simulator.StateCrashed)
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			assert.Equal(c, []string{
+				"3;remoteproc remoteproc0: crash detected in dsp0: type watchdog",
+				"3;remoteproc remoteproc0: handling crash #1 in dsp0",
+			}, readKmsg(t, root)[3:])
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("it logs a recovery", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, kmsgConfig)
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+		selectFirmware(t, r, "some-firmware.elf")
+		require.NoError(t, r.Start())
+		requireState(t, r, simulator.StateRunning)
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultCrash}))
+		requireState(t, r, simulator.StateCrashed)
+
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultRecover}))
+
+		requireState(t, r, simulator.StateRunning)
+		assert.Equal(t, []string{
+			"3;remoteproc remoteproc0: crash detected in dsp0: type fatal error",
+			"3;remoteproc remoteproc0: handling crash #1 in dsp0",
+			"3;remoteproc remoteproc0: recovering dsp0",
+			"6;remoteproc remoteproc0: stopped remote processor dsp0",
+			"6;remoteproc remoteproc0: remote processor dsp0 is now up",
+		}, readKmsg(t, root)[3:])
+	})
+
+	t.Run("it logs an injected boot failure", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, kmsgConfig)
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+		selectFirmware(t, r, "some-firmware.elf")
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultBootFailure}))
+		require.Eventually(t, func() bool { return len(r.FaultLog()) == 1 }, time.Second, time.Millisecond)
+
+		require.NoError(t, r.Start())
+
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			assert.Contains(c, readKmsg(t, root), "3;remoteproc remoteproc0: can't start rproc dsp0: -5")
+		}, time.Second, 10*time.Millisecond)
+		requireState(t, r, simulator.StateOffline)
+	})
+
+	t.Run("it continues the sequence number of a log that rolled over", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "dev"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "dev", "kmsg.1"), []byte("6,41,1234,-;remoteproc remoteproc0: stopped remote processor dsp0\n"), 0644))
+		r, _ := newRootedRemoteproc(t, simulator.Config{RootDir: root, BootDelay: time.Millisecond})
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+		selectFirmware(t, r, "some-firmware.elf")
+
+		require.NoError(t, r.Start())
+		requireState(t, r, simulator.StateRunning)
+
+		content, err := os.ReadFile(filepath.Join(root, "dev", "kmsg"))
+		require.NoError(t, err)
+		assert.Regexp(t, regexp.MustCompile(`^6,42,\d+,-;remoteproc remoteproc0: powering up dsp0\n`), string(content))
+	})
+
+	t.Run("instances sharing a root directory share the sequence number", func(t *testing.T) {
+		root := t.TempDir()
+		for i, name := range []string{"dsp0", "dsp1"} {
+			r := newTestRemoteproc(t, simulator.Config{RootDir: root, Index: uint(i), Name: name, BootDelay: time.Millisecond})
+			createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+			selectFirmware(t, r, "some-firmware.elf")
+			require.NoError(t, r.Start())
+			requireState(t, r, simulator.StateRunning)
+		}
+
+		content, err := os.ReadFile(filepath.Join(root, "dev", "kmsg"))
+		require.NoError(t, err)
+		records := regexp.MustCompile(`(?m)^\d+,(\d+),\d+,-;remoteproc (remoteproc\d):`).FindAllStringSubmatch(string(content), -1)
+		require.Len(t, records, 6)
+		for i, record := range records {
+			assert.Equal(t, strconv.Itoa(i+1), record[1])
+		}
+		assert.Equal(t, "remoteproc0", records[0][2])
+		assert.Equal(t, "remoteproc1", records[5][2])
+	})
+}
+
+// readKmsg reads the kernel log under root, keeping the level and the text of
+// each message.
+func readKmsg(t *testing.T, root string) []string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(root, "dev", "kmsg"))
+	require.NoError(t, err)
+
+	var messages []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" || strings.HasPrefix(line, " ") {
+			continue
+		}
+		prefix, message, ok := strings.Cut(line, ";")
+		require.True(t, ok, line)
+		messages = append(messages, strings.SplitN(prefix, ",", 2)[0]+";"+message)
+	}
+	return messages
+}
@@ -0,0 +1,46 @@
+package simulator
+
+import (
+	"errors"
+	"time"
+)
+
+// LoadTime models how long loading firmware takes from its size. The time
+// it gives is added to BootDelay, the fixed overhead of a boot.
+type LoadTime struct {
+	// BytesPerSecond is how fast the firmware file is read, 0 leaves its
+	// size out of the boot delay. A compressed file counts by its size on
+	// disk
+	BytesPerSecond uint64
+	// SegmentBytesPerSecond is how fast the PT_LOAD segments of an ELF image
+	// are copied to device memory, with DeviceMemory. 0 leaves their size
+	// out of the boot delay
+	SegmentBytesPerSecond uint64
+	// SegmentOverhead is the fixed cost of copying each segment, with
+	// DeviceMemory
+	SegmentOverhead time.Duration
+}
+
+func (l LoadTime) validate() error {
+	if l.SegmentOverhead < 0 {
+		return errors.New("segment load overhead must not be negative")
+	}
+	return nil
+}
+
+// image returns how long reading a firmware file of size bytes takes.
+func (l LoadTime) image(size int) time.Duration {
+	return transferTime(uint64(size), l.BytesPerSecond)
+}
+
+// segment returns how long copying a segment of size bytes takes.
+func (l LoadTime) segment(size uint64) time.Duration {
+	return l.SegmentOverhead + transferTime(size, l.SegmentBytesPerSecond)
+}
+
+func transferTime(size, bytesPerSecond uint64) time.Duration {
+	if bytesPerSecond == 0 {
+		return 0
+	}
+	return time.Duration(float64(size) / float64(bytesPerSecond) * float64(time.Second))
+}
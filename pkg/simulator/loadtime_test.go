@@ -0,0 +1,110 @@
+package simulator_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTime(t *testing.T) {
+	bootCompletionIn := func(t *testing.T, r *simulator.Remoteproc) time.Duration {
+		t.Helper()
+		snapshot, err := r.Snapshot()
+		require.NoError(t, err)
+		require.Equal(t, simulator.StateBooting, snapshot.State)
+		for _, timer := range snapshot.PendingTimers {
+			if timer.Name == "boot completion" {
+				return timer.In
+			}
+		}
+		require.Fail(t, "no boot completion pending")
+		return 0
+	}
+
+	t.Run("it takes the boot delay whatever the size by default", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{})
+		require.NoError(t, bootFirmware(t, r, root, make([]byte, 100_000)))
+
+		assert.InDelta(t, 10*time.Millisecond, bootCompletionIn(t, r), float64(10*time.Millisecond))
+		requireState(t, r, simulator.StateRunning)
+	})
+
+	t.Run("it adds the time reading the image takes to the boot delay", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{LoadTime: simulator.LoadTime{BytesPerSecond: 1000}})
+		require.NoError(t, bootFirmware(t, r, root, make([]byte, 300)))
+
+		assert.InDelta(t, 310*time.Millisecond, bootCompletionIn(t, r), float64(50*time.Millisecond))
+		requireState(t, r, simulator.StateRunning)
+	})
+
+	t.Run("it reads a compressed image by its size on disk", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{LoadTime: simulator.LoadTime{BytesPerSecond: 1000}})
+		encoder, err := zstd.NewWriter(nil)
+		require.NoError(t, err)
+		compressed := encoder.EncodeAll(make([]byte, 100_000), nil)
+		require.Less(t, len(compressed), 100)
+		require.NoError(t, os.WriteFile(filepath.Join(root, "lib", "firmware", "fw.elf.zst"), compressed, 0644))
+		require.NoError(t, r.SetFirmware("fw.elf"))
+		require.NoError(t, r.Start())
+
+		want := 10*time.Millisecond + time.Duration(len(compressed))*time.Millisecond
+		assert.InDelta(t, want, bootCompletionIn(t, r), float64(10*time.Millisecond))
+		requireState(t, r, simulator.StateRunning)
+	})
+
+	t.Run("it adds the time copying each segment takes with device memory", func(t *testing.T) {
+		firmware := testELF{segments: []testSegment{
+			{da: 0x1000_0000, data: []byte("text"), memsz: 100},
+			{da: 0x1000_1000, data: []byte("data"), memsz: 100},
+		}}
+		r, root := newRootedRemoteproc(t, simulator.Config{
+			DeviceMemory:  true,
+			MemoryRegions: []simulator.MemoryRegion{{Name: "dsp-code", Address: 0x1000_0000, Size: 0x2000}},
+			LoadTime:      simulator.LoadTime{SegmentBytesPerSecond: 1000, SegmentOverhead: 50 * time.Millisecond},
+		})
+		require.NoError(t, bootFirmware(t, r, root, firmware.build()))
+
+		assert.InDelta(t, 310*time.Millisecond, bootCompletionIn(t, r), float64(50*time.Millisecond))
+		requireState(t, r, simulator.StateRunning)
+	})
+
+	t.Run("it leaves segments out without device memory", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{LoadTime: simulator.LoadTime{SegmentOverhead: time.Second}})
+		require.NoError(t, bootFirmware(t, r, root, make([]byte, 100)))
+
+		assert.InDelta(t, 10*time.Millisecond, bootCompletionIn(t, r), float64(10*time.Millisecond))
+	})
+
+	t.Run("it applies a new load time from the next boot on", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{})
+		require.NoError(t, bootFirmware(t, r, root, make([]byte, 300)))
+		requireState(t, r, simulator.StateRunning)
+		require.NoError(t, r.Stop())
+
+		require.NoError(t, r.Reconfigure(simulator.Config{
+			RootDir:   root,
+			Name:      "dsp0",
+			BootDelay: 10 * time.Millisecond,
+			LoadTime:  simulator.LoadTime{BytesPerSecond: 1000},
+		}))
+		require.NoError(t, r.Start())
+
+		assert.InDelta(t, 310*time.Millisecond, bootCompletionIn(t, r), float64(50*time.Millisecond))
+	})
+
+	t.Run("it refuses a negative segment overhead", func(t *testing.T) {
+		_, err := simulator.NewRemoteproc(simulator.Config{
+			RootDir:  t.TempDir(),
+			Name:     "dsp0",
+			LoadTime: simulator.LoadTime{SegmentOverhead: -time.Second},
+		})
+
+		assert.ErrorContains(t, err, "segment load overhead must not be negative")
+	})
+}
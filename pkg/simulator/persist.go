@@ -0,0 +1,178 @@
+package simulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// StateStore persists the state of remote processors in a file, so that a
+// new run of the simulator can pick up where the previous one stopped. It is
+// safe for use by several instances.
+type StateStore struct {
+	path string
+
+	mu        sync.Mutex
+	instances []PersistedInstance
+}
+
+// PersistedInstance is what a [StateStore] keeps of a remote processor.
+type PersistedInstance struct {
+	Index    uint     `json:"index"`
+	Name     string   `json:"name"`
+	State    string   `json:"state"`
+	Firmware string   `json:"firmware,omitempty"`
+	Recovery string   `json:"recovery"`
+	Coredump string   `json:"coredump"`
+	Counters Counters `json:"counters"`
+}
+
+type persistedState struct {
+	Instances []PersistedInstance `json:"instances"`
+}
+
+// NewStateStore opens the state file at path, which is created on the first
+// write if it does not exist.
+func NewStateStore(path string) (*StateStore, error) {
+	s := &StateStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	var state persistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	s.instances = state.Instances
+	return s, nil
+}
+
+// Instance returns what was persisted of the instance with the given index.
+func (s *StateStore) Instance(index uint) (PersistedInstance, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(index)
+	if i < 0 {
+		return PersistedInstance{}, false
+	}
+	return s.instances[i], true
+}
+
+func (s *StateStore) find(index uint) int {
+	return slices.IndexFunc(s.instances, func(instance PersistedInstance) bool {
+		return instance.Index == index
+	})
+}
+
+func (s *StateStore) save(instance PersistedInstance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.find(instance.Index); i >= 0 {
+		if s.instances[i] == instance {
+			return nil
+		}
+		s.instances[i] = instance
+	} else {
+		s.instances = append(s.instances, instance)
+	}
+	return s.write()
+}
+
+// write replaces the state file, so that a crash of the simulator never
+// leaves it half written.
+func (s *StateStore) write() error {
+	data, err := json.MarshalIndent(persistedState{Instances: s.instances}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// restore applies what the store kept of the remote processor, if it has the
+// same name. One that was crashed stays so, and one that was running comes
+// back attached: its firmware is assumed to still run, as the kernel assumes
+// of a core booted before it.
+func (r *Remoteproc) restore() {
+	if r.store == nil {
+		return
+	}
+	instance, ok := r.store.Instance(r.index)
+	if !ok {
+		return
+	}
+	if instance.Name != r.name {
+		log.Printf("Not restoring remoteproc%d: it was %s, not %s", r.index, instance.Name, r.name)
+		return
+	}
+	// A name the kernel would refuse leaves the default firmware selected.
+	switch err := validateFirmwareName(instance.Firmware); {
+	case instance.Firmware == "":
+	case err != nil:
+		log.Printf("Ignoring persisted firmware: %s", err)
+	default:
+		r.firmware = instance.Firmware
+	}
+	if instance.Recovery != "" {
+		r.recovery = instance.Recovery
+	}
+	if instance.Coredump != "" {
+		r.coredump = instance.Coredump
+	}
+	r.counters = instance.Counters
+	// A boot in progress never finished loading the firmware, so the remote
+	// processor comes back offline.
+	switch state, _ := stateByName(instance.State); state {
+	case StateRunning, StateSuspended, StateAttached:
+		r.state = StateAttached
+		r.pm.runtimeStatus = runtimeActive
+	case StateCrashed:
+		r.state = StateCrashed
+		r.crashReason = CrashFatalError
+		r.pm.runtimeStatus = runtimeActive
+	}
+	log.Printf("Restored %s in state %s with firmware %s", r.name, r.state, r.firmware)
+}
+
+// persist saves the remote processor to the store, if any.
+func (r *Remoteproc) persist() {
+	if r.store == nil {
+		return
+	}
+	r.mu.RLock()
+	instance := PersistedInstance{
+		Index:    r.index,
+		Name:     r.name,
+		State:    r.state.String(),
+		Firmware: r.firmware,
+		Recovery: r.recovery,
+		Coredump: r.coredump,
+		Counters: r.counters,
+	}
+	r.mu.RUnlock()
+	if err := r.store.save(instance); err != nil {
+		log.Printf("Failed to persist state: %s", err)
+	}
+}
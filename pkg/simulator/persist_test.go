@@ -0,0 +1,138 @@
+package simulator_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateStore(t *testing.T) {
+	newStore := func(t *testing.T, path string) *simulator.StateStore {
+		t.Helper()
+		store, err := simulator.NewStateStore(path)
+		require.NoError(t, err)
+		return store
+	}
+	// restart closes r and creates a remoteproc in the same root directory
+	// from a new store reading the state file.
+	restart := func(t *testing.T, r *simulator.Remoteproc, config simulator.Config, path string) *simulator.Remoteproc {
+		t.Helper()
+		require.NoError(t, r.Close())
+		config.StateStore = newStore(t, path)
+		restarted, err := simulator.NewRemoteproc(config)
+		require.NoError(t, err)
+		t.Cleanup(func() { restarted.Close() })
+		return restarted
+	}
+
+	t.Run("it brings a running remote processor back attached", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		config := simulator.Config{RootDir: t.TempDir(), Name: "dsp0", BootDelay: time.Millisecond}
+		config.StateStore = newStore(t, path)
+		r, err := simulator.NewRemoteproc(config)
+		require.NoError(t, err)
+		createFirmwareFile(t, filepath.Join(config.RootDir, "lib", "firmware", "some-firmware.elf"))
+		require.NoError(t, r.SetFirmware("some-firmware.elf"))
+		require.NoError(t, r.Start())
+		requireState(t, r, simulator.StateRunning)
+
+		r = restart(t, r, config, path)
+
+		assert.Equal(t, simulator.StateAttached, r.State())
+		assert.Equal(t, "some-firmware.elf", r.Firmware())
+		assert.Equal(t, simulator.Counters{Boots: 1}, r.Counters())
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "state"), "attached")
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "firmware"), "some-firmware.elf")
+		assert.ErrorContains(t, r.Start(), "already running")
+		assert.ErrorContains(t, r.SetFirmware("other.elf"), "busy")
+
+		require.NoError(t, r.Stop())
+		assert.Equal(t, simulator.StateOffline, r.State())
+	})
+
+	t.Run("it restores the recovery and coredump settings", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		config := simulator.Config{RootDir: t.TempDir(), Name: "dsp0", StateStore: newStore(t, path)}
+		r, err := simulator.NewRemoteproc(config)
+		require.NoError(t, err)
+		writeInstanceFile(t, r, "recovery", "enabled")
+		writeInstanceFile(t, r, "coredump", "inline")
+		require.Eventually(t, func() bool {
+			return r.Recovery() == "enabled" && r.Coredump() == "inline"
+		}, time.Second, time.Millisecond)
+
+		r = restart(t, r, config, path)
+
+		assert.Equal(t, simulator.StateOffline, r.State())
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "recovery"), "enabled")
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "coredump"), "inline")
+	})
+
+	t.Run("it brings a booting remote processor back offline", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"instances": [{"index": 0, "name": "dsp0", "state": "booting", "firmware": "dsp0.elf"}]}`), 0644))
+
+		r := newTestRemoteproc(t, simulator.Config{RootDir: t.TempDir(), Name: "dsp0", StateStore: newStore(t, path)})
+
+		assert.Equal(t, simulator.StateOffline, r.State())
+		assert.Equal(t, "dsp0.elf", r.Firmware())
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "state"), "offline")
+	})
+
+	t.Run("it ignores what was persisted of a remote processor with another name", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"instances": [{"index": 0, "name": "dsp0", "state": "running", "firmware": "dsp0.elf", "counters": {"boots": 3}}]}`), 0644))
+
+		r := newTestRemoteproc(t, simulator.Config{RootDir: t.TempDir(), Name: "dsp1", StateStore: newStore(t, path)})
+
+		assert.Equal(t, simulator.StateOffline, r.State())
+		assert.Equal(t, "", r.Firmware())
+		assert.Equal(t, simulator.Counters{}, r.Counters())
+	})
+
+	t.Run("it keeps the default firmware when the persisted one is invalid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"instances": [{"index": 0, "name": "dsp0", "state": "offline", "firmware": "../../etc/passwd"}]}`), 0644))
+		config := simulator.Config{RootDir: t.TempDir(), Name: "dsp0", DefaultFirmware: "dsp0.elf", StateStore: newStore(t, path)}
+
+		r := newTestRemoteproc(t, config)
+
+		assert.Equal(t, "dsp0.elf", r.Firmware())
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "firmware"), "dsp0.elf")
+	})
+
+	t.Run("it keeps instances sharing a state file apart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		store := newStore(t, path)
+		root := t.TempDir()
+		dsp0, err := simulator.NewRemoteproc(simulator.Config{RootDir: root, Name: "dsp0", DefaultFirmware: "dsp0.elf", StateStore: store})
+		require.NoError(t, err)
+		t.Cleanup(func() { dsp0.Close() })
+		dsp1, err := simulator.NewRemoteproc(simulator.Config{RootDir: root, Index: 1, Name: "dsp1", DefaultFirmware: "dsp1.elf", StateStore: store})
+		require.NoError(t, err)
+		t.Cleanup(func() { dsp1.Close() })
+
+		restored := newStore(t, path)
+
+		for index, firmware := range []string{"dsp0.elf", "dsp1.elf"} {
+			instance, ok := restored.Instance(uint(index))
+			require.True(t, ok)
+			assert.Equal(t, firmware, instance.Firmware)
+			assert.Equal(t, "offline", instance.State)
+		}
+	})
+
+	t.Run("it refuses a corrupt state file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		require.NoError(t, os.WriteFile(path, []byte("{"), 0644))
+
+		_, err := simulator.NewStateStore(path)
+
+		assert.ErrorContains(t, err, "invalid state file")
+	})
+}
@@ -0,0 +1,326 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/internal/dirwatcher"
+)
+
+const (
+	runtimeStatusFileName    = "runtime_status"
+	controlFileName          = "control"
+	autosuspendDelayFileName = "autosuspend_delay_ms"
+)
+
+// Values of power/runtime_status.
+const (
+	runtimeActive     = "active"
+	runtimeSuspended  = "suspended"
+	runtimeSuspending = "suspending"
+	runtimeResuming   = "resuming"
+	runtimeError      = "error"
+)
+
+// Values of power/control.
+const (
+	controlAuto = "auto"
+	controlOn   = "on"
+)
+
+// powerManagement emulates runtime PM of the remote processor device. Its
+// fields are owned by the remoteproc loop.
+type powerManagement struct {
+	suspendLatency time.Duration
+	resumeLatency  time.Duration
+	// control is "auto" when runtime PM may suspend the device, "on" when it
+	// must keep it active
+	control string
+	// autosuspendDelayMs is how long the remote processor may stay idle
+	// before it is suspended. Negative values disable autosuspend.
+	autosuspendDelayMs int
+	runtimeStatus      string
+	failNextResume     bool
+
+	watcher *dirwatcher.DirWatcher
+	// transition is the timer completing a suspend or resume in progress
+	transition    *loopTimer
+	transitionID  int
+	autosuspend   *loopTimer
+	autosuspendID int
+}
+
+func newPowerManagement(suspendLatency, resumeLatency, autosuspendDelay time.Duration) powerManagement {
+	autosuspendDelayMs := -1
+	if autosuspendDelay > 0 {
+		autosuspendDelayMs = int(autosuspendDelay / time.Millisecond)
+	}
+	return powerManagement{
+		suspendLatency:     suspendLatency,
+		resumeLatency:      resumeLatency,
+		control:            controlAuto,
+		autosuspendDelayMs: autosuspendDelayMs,
+		runtimeStatus:      runtimeSuspended,
+	}
+}
+
+// Suspend puts the running remote processor to sleep, as runtime PM does once
+// it is idle. It returns once the suspend has been initiated; it completes
+// after the suspend latency.
+func (r *Remoteproc) Suspend() error {
+	return r.send(r.suspend)
+}
+
+// Resume wakes the suspended remote processor up. It returns once the resume
+// has been initiated; it completes after the resume latency, unless the
+// resume fails.
+func (r *Remoteproc) Resume() error {
+	return r.send(r.resume)
+}
+
+// FailNextResume makes the next resume fail, which leaves the device in the
+// runtime PM error state and the remote processor crashed.
+func (r *Remoteproc) FailNextResume() error {
+	return r.send(func() error {
+		r.pm.failNextResume = true
+		return nil
+	})
+}
+
+// RuntimeStatus returns the content of power/runtime_status.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) RuntimeStatus() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pm.runtimeStatus
+}
+
+func (r *Remoteproc) bootstrapPowerFiles() error {
+	if err := r.fs.BootstrapPowerDirectory(); err != nil {
+		return err
+	}
+	files := map[string]string{
+		controlFileName:          r.pm.control,
+		runtimeStatusFileName:    r.pm.runtimeStatus,
+		autosuspendDelayFileName: strconv.Itoa(r.pm.autosuspendDelayMs),
+	}
+	for filename, content := range files {
+		if err := r.fs.WritePowerFile(filename, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Remoteproc) startPowerWatcher() error {
+	watcher, err := dirwatcher.New(context.Background(), r.fs.PowerDir(), r.watcherConfig)
+	if err != nil {
+		return fmt.Errorf("failed to watch power directory: %w", err)
+	}
+	r.pm.watcher = watcher
+	return nil
+}
+
+func (r *Remoteproc) suspend() error {
+	switch {
+	case r.pm.runtimeStatus == runtimeSuspending || r.state == StateSuspended:
+		return errors.New("remoteproc is already suspended")
+	case r.pm.runtimeStatus == runtimeResuming || r.state == StateBooting:
+		return fmt.Errorf("cannot suspend remoteproc while %s: %w", r.pm.runtimeStatus, syscall.EBUSY)
+	case r.state != StateRunning:
+		return fmt.Errorf("cannot suspend remoteproc in state %s: %w", r.state, syscall.EINVAL)
+	}
+
+	log.Printf("Suspending remoteproc")
+	r.stopAutosuspend()
+	r.setRuntimeStatus(runtimeSuspending)
+	r.startTransition(r.pm.suspendLatency, func() {
+		log.Printf("Remoteproc suspended")
+		r.setState(StateSuspended)
+	})
+	return nil
+}
+
+func (r *Remoteproc) resume() error {
+	switch {
+	case r.pm.runtimeStatus == runtimeSuspending:
+		// Resuming a device that is still suspending aborts the suspend.
+		log.Printf("Remoteproc suspend aborted")
+		r.cancelTransition()
+		r.setRuntimeStatus(runtimeActive)
+		r.armAutosuspend()
+		return nil
+	case r.pm.runtimeStatus == runtimeResuming:
+		return nil
+	case r.state != StateSuspended:
+		return fmt.Errorf("cannot resume remoteproc in state %s: %w", r.state, syscall.EINVAL)
+	}
+
+	log.Printf("Resuming remoteproc")
+	r.setRuntimeStatus(runtimeResuming)
+	r.startTransition(r.pm.resumeLatency, func() {
+		if r.pm.failNextResume {
+			r.pm.failNextResume = false
+			log.Printf("Failed to resume remoteproc")
+			r.setRuntimeStatus(runtimeError)
+			r.reportCrash(CrashFatalError)
+			return
+		}
+		log.Printf("Remoteproc resumed")
+		r.setState(StateRunning)
+	})
+	return nil
+}
+
+// markActive records activity of the host, like pm_runtime_get followed by
+// pm_runtime_put_autosuspend: a suspended remote processor is woken up and
+// the autosuspend delay starts over.
+func (r *Remoteproc) markActive() {
+	switch {
+	case r.state == StateSuspended || r.pm.runtimeStatus == runtimeSuspending:
+		if err := r.resume(); err != nil {
+			log.Printf("Failed to wake remoteproc up: %s", err)
+		}
+	case r.state == StateRunning:
+		r.armAutosuspend()
+	}
+}
+
+func (r *Remoteproc) startTransition(latency time.Duration, complete func()) {
+	r.cancelTransition()
+	id := r.pm.transitionID
+	r.pm.transition = r.afterFunc(latency, func() {
+		if id != r.pm.transitionID {
+			return
+		}
+		r.pm.transition = nil
+		complete()
+	})
+}
+
+func (r *Remoteproc) cancelTransition() {
+	if r.pm.transition != nil {
+		r.pm.transition.Stop()
+		r.pm.transition = nil
+	}
+	r.pm.transitionID++
+}
+
+// armAutosuspend (re)starts the autosuspend delay when runtime PM may
+// suspend the running remote processor.
+func (r *Remoteproc) armAutosuspend() {
+	r.stopAutosuspend()
+	if r.state != StateRunning || r.pm.runtimeStatus != runtimeActive ||
+		r.pm.control != controlAuto || r.pm.autosuspendDelayMs < 0 {
+		return
+	}
+	id := r.pm.autosuspendID
+	delay := time.Duration(r.pm.autosuspendDelayMs) * time.Millisecond
+	r.pm.autosuspend = r.afterFunc(delay, func() {
+		if id != r.pm.autosuspendID {
+			return
+		}
+		r.pm.autosuspend = nil
+		log.Printf("Remoteproc idle for %s", delay)
+		if err := r.suspend(); err != nil {
+			log.Printf("Autosuspend failed: %s", err)
+		}
+	})
+}
+
+func (r *Remoteproc) stopAutosuspend() {
+	if r.pm.autosuspend != nil {
+		r.pm.autosuspend.Stop()
+		r.pm.autosuspend = nil
+	}
+	r.pm.autosuspendID++
+}
+
+// updatePowerState keeps runtime PM in line with a new state of the remote
+// processor.
+func (r *Remoteproc) updatePowerState(state State) {
+	switch state {
+	case StateRunning:
+		r.setRuntimeStatus(runtimeActive)
+		r.armAutosuspend()
+	case StateBooting, StateAttached:
+		r.setRuntimeStatus(runtimeActive)
+	case StateSuspended:
+		r.setRuntimeStatus(runtimeSuspended)
+	case StateOffline:
+		r.cancelTransition()
+		r.stopAutosuspend()
+		r.setRuntimeStatus(runtimeSuspended)
+	case StateCrashed:
+		r.cancelTransition()
+		r.stopAutosuspend()
+		if r.pm.runtimeStatus != runtimeError {
+			r.setRuntimeStatus(runtimeActive)
+		}
+	}
+}
+
+func (r *Remoteproc) setRuntimeStatus(status string) {
+	r.mu.Lock()
+	r.pm.runtimeStatus = status
+	r.mu.Unlock()
+	r.fs.WritePowerFile(runtimeStatusFileName, status)
+}
+
+// handlePowerWrite handles writes to the files of the power directory. Like
+// sysfs, invalid values are refused, which here means restoring the file.
+func (r *Remoteproc) handlePowerWrite(filename, value string) {
+	switch filename {
+	case controlFileName:
+		if value == r.pm.control {
+			return
+		}
+		switch value {
+		case controlOn:
+			r.pm.control = controlOn
+			r.stopAutosuspend()
+			if r.state == StateSuspended || r.pm.runtimeStatus == runtimeSuspending {
+				if err := r.resume(); err != nil {
+					log.Printf("Failed to resume remoteproc: %s", err)
+				}
+			}
+		case controlAuto:
+			r.pm.control = controlAuto
+			r.armAutosuspend()
+		default:
+			log.Printf("Invalid power control %q", value)
+			r.fs.WritePowerFile(controlFileName, r.pm.control)
+		}
+	case autosuspendDelayFileName:
+		current := strconv.Itoa(r.pm.autosuspendDelayMs)
+		if value == current {
+			return
+		}
+		delayMs, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("Invalid autosuspend delay %q", value)
+			r.fs.WritePowerFile(autosuspendDelayFileName, current)
+			return
+		}
+		r.pm.autosuspendDelayMs = delayMs
+		r.armAutosuspend()
+	case runtimeStatusFileName:
+		if value != r.pm.runtimeStatus {
+			r.fs.WritePowerFile(runtimeStatusFileName, r.pm.runtimeStatus)
+		}
+	}
+}
+
+// Changes returns the writes to the power directory, or nil before it is
+// watched.
+func (p *powerManagement) Changes() <-chan dirwatcher.FileChangeEvent {
+	if p.watcher == nil {
+		return nil
+	}
+	return p.watcher.Changes()
+}
@@ -0,0 +1,157 @@
+package simulator_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPowerManagement(t *testing.T) {
+	const latency = 20 * time.Millisecond
+
+	powerFile := func(r *simulator.Remoteproc, filename string) string {
+		return filepath.Join(r.InstanceDir(), "power", filename)
+	}
+	requireRuntimeStatus := func(t *testing.T, r *simulator.Remoteproc, want string) {
+		t.Helper()
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			assert.Equal(c, want, r.RuntimeStatus())
+			got, err := os.ReadFile(powerFile(r, "runtime_status"))
+			if assert.NoError(c, err) {
+				assert.Equal(c, want, string(got))
+			}
+		}, time.Second, 5*time.Millisecond)
+	}
+
+	t.Run("it creates the power files of an offline device", func(t *testing.T) {
+		r := newTestRemoteproc(t, simulator.Config{RootDir: t.TempDir(), Name: "dsp0"})
+
+		assertFileContent(t, powerFile(r, "runtime_status"), "suspended")
+		assertFileContent(t, powerFile(r, "control"), "auto")
+		assertFileContent(t, powerFile(r, "autosuspend_delay_ms"), "-1")
+	})
+
+	t.Run("it suspends and resumes after their latencies", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{SuspendLatency: latency, ResumeLatency: latency})
+		requireRuntimeStatus(t, r, "active")
+
+		require.NoError(t, r.Suspend())
+		assert.Equal(t, "suspending", r.RuntimeStatus())
+		assert.Equal(t, simulator.StateRunning, r.State())
+		requireState(t, r, simulator.StateSuspended)
+		requireRuntimeStatus(t, r, "suspended")
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "state"), "suspended")
+
+		require.NoError(t, r.Resume())
+		assert.Equal(t, "resuming", r.RuntimeStatus())
+		requireState(t, r, simulator.StateRunning)
+		requireRuntimeStatus(t, r, "active")
+	})
+
+	t.Run("it rejects suspending a device that is not running", func(t *testing.T) {
+		r := newTestRemoteproc(t, simulator.Config{RootDir: t.TempDir(), Name: "dsp0"})
+
+		assert.ErrorIs(t, r.Suspend(), syscall.EINVAL)
+		assert.ErrorIs(t, r.Resume(), syscall.EINVAL)
+	})
+
+	t.Run("it rejects start while suspended and stops a suspended device", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{})
+		require.NoError(t, r.Suspend())
+		requireState(t, r, simulator.StateSuspended)
+
+		assert.ErrorContains(t, r.Start(), "suspended")
+		require.NoError(t, r.Stop())
+
+		assert.Equal(t, simulator.StateOffline, r.State())
+		requireRuntimeStatus(t, r, "suspended")
+	})
+
+	t.Run("it aborts a suspend in progress when resumed", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{SuspendLatency: latency})
+
+		require.NoError(t, r.Suspend())
+		require.NoError(t, r.Resume())
+
+		assertNoPendingTimer(t, r, "power transition")
+		assert.Equal(t, simulator.StateRunning, r.State())
+		assert.Equal(t, "active", r.RuntimeStatus())
+	})
+
+	t.Run("it crashes the remote processor when a resume fails", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{})
+		require.NoError(t, r.Suspend())
+		requireState(t, r, simulator.StateSuspended)
+
+		require.NoError(t, r.FailNextResume())
+		require.NoError(t, r.Resume())
+
+		requireState(t, r, simulator.StateCrashed)
+		requireRuntimeStatus(t, r, "error")
+	})
+
+	t.Run("it autosuspends an idle device", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{AutosuspendDelay: latency})
+
+		assertFileContent(t, powerFile(r, "autosuspend_delay_ms"), "20")
+		requireState(t, r, simulator.StateSuspended)
+	})
+
+	t.Run("it enables autosuspend through sysfs", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{})
+
+		require.NoError(t, os.WriteFile(powerFile(r, "autosuspend_delay_ms"), []byte("10"), 0644))
+
+		requireState(t, r, simulator.StateSuspended)
+	})
+
+	t.Run("it keeps the device active and resumes it when control is on", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{AutosuspendDelay: latency})
+		requireState(t, r, simulator.StateSuspended)
+
+		require.NoError(t, os.WriteFile(powerFile(r, "control"), []byte("on"), 0644))
+
+		requireState(t, r, simulator.StateRunning)
+		assertNoPendingTimer(t, r, "autosuspend")
+	})
+
+	t.Run("it restores power files given invalid values", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{})
+
+		require.NoError(t, os.WriteFile(powerFile(r, "control"), []byte("off"), 0644))
+		require.NoError(t, os.WriteFile(powerFile(r, "runtime_status"), []byte("suspended"), 0644))
+
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			control, err := os.ReadFile(powerFile(r, "control"))
+			assert.NoError(c, err)
+			assert.Equal(c, "auto", string(control))
+			status, err := os.ReadFile(powerFile(r, "runtime_status"))
+			assert.NoError(c, err)
+			assert.Equal(c, "active", string(status))
+		}, time.Second, 5*time.Millisecond)
+		assert.Equal(t, simulator.StateRunning, r.State())
+	})
+
+	t.Run("it wakes a suspended device up on a kick", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{Doorbell: true})
+		require.NoError(t, r.Suspend())
+		requireState(t, r, simulator.StateSuspended)
+
+		root := filepath.Join(r.InstanceDir(), "..", "..", "..", "..")
+		host, err := net.Dial("unix", filepath.Join(root, "run", "remoteproc0", "doorbell"))
+		require.NoError(t, err)
+		defer host.Close()
+		_, err = fmt.Fprintln(host, "0")
+		require.NoError(t, err)
+
+		requireState(t, r, simulator.StateRunning)
+	})
+}
@@ -0,0 +1,147 @@
+package simulator
+
+import (
+	"fmt"
+	"log"
+	"syscall"
+)
+
+const (
+	recoveryFileName = "recovery"
+	coredumpFileName = "coredump"
+)
+
+// Values of the recovery file.
+const (
+	recoveryEnabled  = "enabled"
+	recoveryDisabled = "disabled"
+)
+
+// Values of the coredump file. Writing "enabled" selects "default".
+const (
+	coredumpDefault  = "default"
+	coredumpEnabled  = "enabled"
+	coredumpInline   = "inline"
+	coredumpDisabled = "disabled"
+)
+
+// Counters count what happened to the remote processor.
+type Counters struct {
+	// Boots counts the boots that completed
+	Boots uint64 `json:"boots"`
+	// Crashes counts the crashes
+	Crashes uint64 `json:"crashes"`
+}
+
+// Counters returns the counters of the remote processor.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) Counters() Counters {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.counters
+}
+
+// Recovery returns the content of the recovery file, "enabled" or
+// "disabled". The setting is kept and persisted, but a crashed remote
+// processor stays crashed either way until a FaultRecover recovers it.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) Recovery() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.recovery
+}
+
+// Coredump returns the content of the coredump file.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) Coredump() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.coredump
+}
+
+func (r *Remoteproc) updateCounters(update func(*Counters)) {
+	r.mu.Lock()
+	update(&r.counters)
+	r.mu.Unlock()
+}
+
+// handleRecoveryWrite is recovery_store.
+func (r *Remoteproc) handleRecoveryWrite(value string) {
+	if value == r.recovery {
+		return
+	}
+	switch value {
+	case recoveryEnabled, recoveryDisabled:
+		r.setRecovery(value)
+		log.Printf("Recovery %s", value)
+	default:
+		log.Printf("Invalid recovery %q", value)
+		r.fs.WriteInstanceFile(recoveryFileName, r.recovery)
+	}
+}
+
+// handleCoredumpWrite is coredump_store.
+func (r *Remoteproc) handleCoredumpWrite(value string) {
+	if value == r.coredump {
+		return
+	}
+	err := r.changeCoredump(value)
+	if err != nil {
+		log.Printf("Coredump change rejected: %s", err)
+	}
+	r.fs.WriteInstanceFile(coredumpFileName, r.coredump)
+}
+
+func (r *Remoteproc) changeCoredump(value string) error {
+	if r.state == StateCrashed {
+		return fmt.Errorf("cannot change coredump of a crashed remoteproc: %w", syscall.EBUSY)
+	}
+	switch value {
+	case coredumpEnabled:
+		value = coredumpDefault
+	case coredumpInline, coredumpDisabled:
+	default:
+		return fmt.Errorf("invalid coredump %q: %w", value, syscall.EINVAL)
+	}
+	r.mu.Lock()
+	r.coredump = value
+	r.mu.Unlock()
+	r.persist()
+	log.Printf("Coredump set to %s", value)
+	return nil
+}
+
+func (r *Remoteproc) setRecovery(value string) {
+	r.mu.Lock()
+	r.recovery = value
+	r.mu.Unlock()
+	r.fs.WriteInstanceFile(recoveryFileName, value)
+	r.persist()
+}
+
+// recover is rproc_trigger_recovery: the crashed remote processor boots the
+// same firmware again. A recovery that fails leaves it crashed, so that it can
+// be recovered again.
+func (r *Remoteproc) recover() {
+	log.Printf("Recovering %s", r.name)
+	r.kmsg(kmsgErr, "recovering %s", r.name)
+	r.kmsg(kmsgInfo, "stopped remote processor %s", r.name)
+	r.recovering = true
+	if err := r.boot(); err != nil {
+		log.Printf("Recovery failed: %s", err)
+	}
+}
+
+// countTransition counts boots and crashes. A recovery that fails isn't
+// counted as another crash.
+func (r *Remoteproc) countTransition(previous, state State) {
+	if previous == state {
+		return
+	}
+	switch {
+	case previous == StateBooting && state == StateRunning:
+		r.updateCounters(func(c *Counters) { c.Boots++ })
+	case state == StateCrashed && previous != StateBooting:
+		r.updateCounters(func(c *Counters) { c.Crashes++ })
+	}
+}
@@ -0,0 +1,73 @@
+package simulator_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecovery(t *testing.T) {
+	t.Run("it keeps the recovery setting written through sysfs", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0"})
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "recovery"), "disabled")
+
+		writeInstanceFile(t, r, "recovery", "enabled")
+
+		require.Eventually(t, func() bool { return r.Recovery() == "enabled" }, time.Second, time.Millisecond)
+	})
+
+	t.Run("it reverts invalid recovery values", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0"})
+
+		writeInstanceFile(t, r, "recovery", "sometimes")
+
+		requireInstanceFile(t, r, "recovery", "disabled")
+	})
+}
+
+func TestCoredump(t *testing.T) {
+	t.Run("it selects the coredump mode", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0"})
+		path := filepath.Join(r.InstanceDir(), "coredump")
+		assertFileContent(t, path, "default")
+
+		writeInstanceFile(t, r, "coredump", "inline")
+		require.Eventually(t, func() bool { return r.Coredump() == "inline" }, time.Second, time.Millisecond)
+
+		writeInstanceFile(t, r, "coredump", "enabled")
+		require.Eventually(t, func() bool { return r.Coredump() == "default" }, time.Second, time.Millisecond)
+		requireInstanceFile(t, r, "coredump", "default")
+	})
+
+	t.Run("it refuses changes while crashed", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: time.Millisecond})
+		require.NoError(t, r.Start())
+		requireState(t, r, simulator.StateRunning)
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultCrash}))
+		requireState(t, r, simulator.StateCrashed)
+
+		writeInstanceFile(t, r, "coredump", "disabled")
+
+		requireInstanceFile(t, r, "coredump", "default")
+		assert.Equal(t, "default", r.Coredump())
+	})
+}
+
+func TestCounters(t *testing.T) {
+	t.Run("it counts boots and crashes", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{})
+
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultCrash}))
+		requireState(t, r, simulator.StateCrashed)
+		require.NoError(t, r.Stop())
+		requireRunning(t, r)
+
+		snapshot, err := r.Snapshot()
+		require.NoError(t, err)
+		assert.Equal(t, simulator.Counters{Boots: 2, Crashes: 1}, snapshot.Counters)
+	})
+}
@@ -0,0 +1,102 @@
+package simulator
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+)
+
+// Reconfigure applies the tunables of config to the remote processor without
+// disturbing it:
+//   - BootDelay and LoadTime apply from the next boot on
+//   - a new WatchdogTimeout restarts the watchdog of a running remote processor
+//   - Faults that were not in the previous configuration are scheduled, their
+//     delay starting now, and those no longer in it are cancelled
+//   - a new Chaos plans faults at the new rates from now on
+//
+// Other fields only take effect when a remoteproc is created, and Index and
+// Name cannot change.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) Reconfigure(config Config) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	config = config.withDefaults()
+	if config.Index != r.index || config.Name != r.name {
+		return errors.New("index and name of a remoteproc cannot change")
+	}
+	return r.send(func() error {
+		r.reconfigure(config)
+		return nil
+	})
+}
+
+func (r *Remoteproc) reconfigure(config Config) {
+	if config.BootDelay != r.bootDelay {
+		log.Printf("Boot delay of %s set to %s", r.name, config.BootDelay)
+		r.bootDelay = config.BootDelay
+	}
+	if config.LoadTime != r.loadTime {
+		log.Printf("Load time of %s set to %+v", r.name, config.LoadTime)
+		r.loadTime = config.LoadTime
+	}
+	if config.WatchdogTimeout != r.watchdog.timeout {
+		log.Printf("Watchdog timeout of %s set to %s", r.name, config.WatchdogTimeout)
+		r.stopWatchdog()
+		r.watchdog.timeout = config.WatchdogTimeout
+		r.updateWatchdog(r.state)
+	}
+	r.applyFaults(config.Faults)
+	r.applyChaos(config.Chaos, time.Since(r.faults.start))
+}
+
+// applyFaults brings the faults of the configuration to the timeline: those
+// that are new are scheduled, those that are gone are cancelled.
+func (r *Remoteproc) applyFaults(faults []Fault) {
+	gone := slices.Clone(r.configFaults)
+	var added []Fault
+	for _, fault := range faults {
+		if i := slices.Index(gone, fault); i >= 0 {
+			gone = slices.Delete(gone, i, i+1)
+		} else {
+			added = append(added, fault)
+		}
+	}
+	for _, fault := range gone {
+		r.unscheduleFault(fault)
+	}
+	for _, fault := range added {
+		r.scheduleFault(fault)
+	}
+	r.configFaults = slices.Clone(faults)
+}
+
+func (r *Remoteproc) unscheduleFault(fault Fault) {
+	i := slices.IndexFunc(r.faults.faults, func(f *scheduledFault) bool {
+		return f.Fault == fault
+	})
+	if i < 0 {
+		return
+	}
+	r.disarmFault(r.faults.faults[i])
+	r.faults.faults = slices.Delete(r.faults.faults, i, i+1)
+	log.Printf("Fault cancelled for %s: %s", r.name, fault)
+}
+
+// applyChaos starts chaos over when its configuration changed, planning
+// faults from the given offset from the start of the timeline on.
+func (r *Remoteproc) applyChaos(chaos Chaos, from time.Duration) {
+	if chaos == r.chaosConfig {
+		return
+	}
+	if len(r.chaos) > 0 && !chaos.enabled() {
+		log.Printf("Chaos for %s stopped", r.name)
+	}
+	r.stopChaos()
+	r.chaos = nil
+	r.chaosConfig = chaos
+	if chaos.enabled() {
+		r.startChaos(chaos, from)
+	}
+}
@@ -0,0 +1,112 @@
+package simulator_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconfigure(t *testing.T) {
+	const delay = 20 * time.Millisecond
+
+	t.Run("it applies a new boot delay from the next boot on", func(t *testing.T) {
+		config := simulator.Config{RootDir: t.TempDir(), Name: "dsp0", BootDelay: time.Millisecond}
+		r := newRunningRemoteproc(t, config)
+
+		config.BootDelay = 5 * delay
+		require.NoError(t, r.Reconfigure(config))
+
+		assert.Equal(t, simulator.StateRunning, r.State())
+		require.NoError(t, r.Stop())
+		require.NoError(t, r.Start())
+		time.Sleep(2 * delay)
+		assert.Equal(t, simulator.StateBooting, r.State())
+		requireState(t, r, simulator.StateRunning)
+	})
+
+	t.Run("it restarts the watchdog with a new timeout", func(t *testing.T) {
+		config := simulator.Config{RootDir: t.TempDir(), Name: "dsp0", BootDelay: time.Millisecond, WatchdogTimeout: time.Hour}
+		r := newRunningRemoteproc(t, config)
+
+		config.WatchdogTimeout = delay
+		require.NoError(t, r.Reconfigure(config))
+
+		requireState(t, r, simulator.StateCrashed)
+		assert.Equal(t, simulator.CrashWatchdog, r.CrashReason())
+	})
+
+	t.Run("it schedules new faults and cancels those gone", func(t *testing.T) {
+		gone := simulator.Fault{Kind: simulator.FaultCrash, After: 5 * delay}
+		config := simulator.Config{RootDir: t.TempDir(), Name: "dsp0", BootDelay: time.Millisecond, Faults: []simulator.Fault{gone}}
+		r := newRunningRemoteproc(t, config)
+
+		config.Faults = []simulator.Fault{{Kind: simulator.FaultStopHang}}
+		require.NoError(t, r.Reconfigure(config))
+		time.Sleep(10 * delay)
+
+		faults := r.FaultLog()
+		require.Len(t, faults, 1)
+		assert.Equal(t, simulator.FaultStopHang, faults[0].Fault.Kind)
+		assert.Equal(t, simulator.StateRunning, r.State())
+	})
+
+	t.Run("it keeps faults that did not change", func(t *testing.T) {
+		kept := simulator.Fault{Kind: simulator.FaultCrash, After: 5 * delay}
+		config := simulator.Config{RootDir: t.TempDir(), Name: "dsp0", BootDelay: time.Millisecond, Faults: []simulator.Fault{kept}}
+		r := newRunningRemoteproc(t, config)
+
+		require.NoError(t, r.Reconfigure(config))
+
+		requireState(t, r, simulator.StateCrashed)
+		time.Sleep(2 * delay)
+		assert.Len(t, r.FaultLog(), 1)
+	})
+
+	t.Run("it starts and stops chaos", func(t *testing.T) {
+		config := simulator.Config{RootDir: t.TempDir(), Name: "dsp0", BootDelay: time.Millisecond}
+		r := newRunningRemoteproc(t, config)
+
+		config.Chaos = simulator.Chaos{CrashRate: 60 * 1000}
+		require.NoError(t, r.Reconfigure(config))
+		requireState(t, r, simulator.StateCrashed)
+
+		config.Chaos = simulator.Chaos{}
+		require.NoError(t, r.Reconfigure(config))
+		count := len(r.FaultLog())
+		time.Sleep(5 * delay)
+		assert.Len(t, r.FaultLog(), count)
+	})
+
+	t.Run("it can be fed while the watchdog timeout changes", func(t *testing.T) {
+		config := simulator.Config{RootDir: t.TempDir(), Name: "dsp0", BootDelay: time.Millisecond, WatchdogTimeout: time.Hour}
+		r := newRunningRemoteproc(t, config)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := range 20 {
+				config.WatchdogTimeout = time.Duration(i%2) * time.Hour
+				assert.NoError(t, r.Reconfigure(config))
+			}
+		}()
+		for range 20 {
+			err := r.FeedWatchdog()
+			if err != nil {
+				assert.ErrorIs(t, err, simulator.ErrNoWatchdog)
+			}
+		}
+		<-done
+	})
+
+	t.Run("it refuses to rename a remote processor", func(t *testing.T) {
+		config := simulator.Config{RootDir: t.TempDir(), Name: "dsp0", BootDelay: time.Millisecond}
+		r := newRunningRemoteproc(t, config)
+
+		config.Name = "dsp1"
+
+		assert.ErrorContains(t, r.Reconfigure(config), "cannot change")
+	})
+}
@@ -1,21 +1,94 @@
 package simulator
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/arm/remoteproc-simulator/internal/dirwatcher"
 )
 
+// Remoteproc simulates a single remote processor.
+//
+// All state is owned by the loop goroutine: sysfs writes and timers are
+// delivered to it as messages, and it is the only place where state and
+// firmware are mutated. The mutex only guards reads made through the public
+// accessors.
 type Remoteproc struct {
-	name     string
-	fs       *FileSystemManager
-	watcher  *dirwatcher.DirWatcher
-	state    state
-	firmware string
-	stopChan chan struct{}
+	name          string
+	fs            *FileSystemManager
+	watcher       *dirwatcher.DirWatcher
+	watcherConfig dirwatcher.Config
+
+	mu           sync.RWMutex
+	state        State
+	firmware     string
+	firmwarePath string
+	// firmwareResolvedPath is firmwarePath with symbolic links resolved
+	firmwareResolvedPath string
+
+	index          uint
+	autoBoot       bool
+	cdevEnabled    bool
+	cdev           *cdev
+	cdevOwner      *cdevConn
+	doorbellConfig doorbellConfig
+	doorbell       *doorbell
+	image          firmwareImage
+	bootDelay      time.Duration
+	loadTime       LoadTime
+	bootTimer      *loopTimer
+	bootID         uint64
+	fallback       *fallbackLoader
+	fallbackConfig fallbackConfig
+	deviceTree     deviceTreeConfig
+	memoryRegions  []MemoryRegion
+	// deviceMemory enables loading firmware as an ELF image into carveouts
+	deviceMemory bool
+	carveouts    []*carveout
+	// vrings enables laying out the vrings of vdev resources
+	vrings bool
+	vdevs  []*Vdev
+	// loadingVdevs are the vdevs of the firmware being loaded, published
+	// once their memory is allocated
+	loadingVdevs []*Vdev
+	nextNotifyID uint32
+	pm           powerManagement
+	watchdog     watchdog
+	faults       faultSchedule
+	chaos        []*chaosStream
+	// configFaults and chaosConfig are the Faults and Chaos of the Config
+	// last applied, see Reconfigure
+	configFaults []Fault
+	chaosConfig  Chaos
+	crashReason  CrashReason
+	recovery     string
+	// recovering tells that the boot in progress recovers a crash
+	recovering  bool
+	coredump    string
+	counters    Counters
+	store       *StateStore
+	adoptDir    bool
+	cleanupMode CleanupPolicy
+	keepFailed  bool
+	startFailed bool
+	stopFailed  bool
+	timers      chan func()
+	commands    chan command
+	stopChan    chan struct{}
+	loopDone    chan struct{}
+}
+
+// command is a request made through the Go API, run on the loop goroutine.
+type command struct {
+	run    func() error
+	result chan error
 }
 
 const (
@@ -23,9 +96,13 @@ const (
 	stateFileName    = "state"
 	nameFileName     = "name"
 	initialState     = StateOffline
-	initialFirmware  = ""
+	defaultBootDelay = 100 * time.Millisecond
+
+	defaultKernelRelease = "6.12.0-remoteproc-simulator"
 )
 
+var ErrClosed = errors.New("remoteproc is closed")
+
 type Config struct {
 	// RootDir is the location where /sys and /lib will be created
 	RootDir string
@@ -33,6 +110,97 @@ type Config struct {
 	Index uint
 	// Name is the remote processor name written to /sys/class/remoteproc/.../name
 	Name string
+	// DefaultFirmware is the firmware selected at start up, as a driver does
+	// with its firmware-name. Empty by default.
+	DefaultFirmware string
+	// AutoBoot boots the remote processor as soon as it is created, like
+	// rproc->auto_boot. Without DefaultFirmware, the kernel's default name
+	// rproc-<Name>-fw is used.
+	AutoBoot bool
+	// BootDelay is how long firmware loading takes, defaults to 100ms
+	BootDelay time.Duration
+	// LoadTime makes firmware loading take longer the larger the firmware,
+	// on top of BootDelay
+	LoadTime LoadTime
+	// Watcher selects how writes to sysfs files are detected: "inotify",
+	// "poll" or "auto" (default), which falls back to polling when inotify is
+	// unavailable
+	Watcher string
+	// PollInterval is how often the "poll" watcher scans for changes, defaults to 50ms
+	PollInterval time.Duration
+	// KernelRelease is the emulated `uname -r`, which selects the release
+	// specific firmware directories, defaults to 6.12.0-remoteproc-simulator
+	KernelRelease string
+	// CharDevice creates /dev/remoteprocN, emulated with a Unix socket
+	CharDevice bool
+	// FirmwareFallback enables the firmware_class sysfs fallback: a missing
+	// firmware can then be supplied through /sys/class/firmware/<name>/
+	FirmwareFallback bool
+	// FirmwareFallbackTimeout is the initial content of /sys/class/firmware/timeout,
+	// defaults to 60s
+	FirmwareFallbackTimeout time.Duration
+	// DeviceTree describes the remote processor in /sys/firmware/devicetree/base,
+	// also reachable through /proc/device-tree, and links its of_node there
+	DeviceTree bool
+	// Compatible is the compatible string of the device tree node, defaults
+	// to sim,remoteproc
+	Compatible string
+	// MemoryRegions are the reserved-memory regions of the remote processor,
+	// referenced by the memory-region property of its device tree node. With
+	// DeviceMemory, they are carveouts available to the firmware
+	MemoryRegions []MemoryRegion
+	// DeviceMemory loads firmware like the kernel's ELF loader: carveouts of
+	// the resource table and MemoryRegions are backed by files under
+	// /run/remoteprocN/memory, and PT_LOAD segments are copied into them.
+	// Firmware must then be an ELF image
+	DeviceMemory bool
+	// Vrings lays out the vrings and buffer pool of each vdev of the resource
+	// table in device memory, see [Remoteproc.Vdevs]. Requires DeviceMemory
+	Vrings bool
+	// Doorbell creates /run/remoteprocN/doorbell, a Unix socket carrying
+	// kicks and interrupts, and counts them in
+	// /sys/kernel/debug/remoteproc/remoteprocN/kicks
+	Doorbell bool
+	// DoorbellFaults are injected in doorbell notifications
+	DoorbellFaults DoorbellFaults
+	// Mailboxes are the mbox-names of the device tree node, each given a
+	// channel of the /mailbox controller
+	Mailboxes []string
+	// SuspendLatency is how long suspending the remote processor takes
+	SuspendLatency time.Duration
+	// ResumeLatency is how long resuming the remote processor takes
+	ResumeLatency time.Duration
+	// AutosuspendDelay is how long the running remote processor may stay
+	// idle before runtime PM suspends it. Zero disables autosuspend, which
+	// can also be enabled through power/autosuspend_delay_ms
+	AutosuspendDelay time.Duration
+	// WatchdogTimeout is how long the running firmware may go without
+	// feeding its watchdog before it crashes with CrashWatchdog. Zero
+	// disables the watchdog
+	WatchdogTimeout time.Duration
+	// HeartbeatEndpoint is the RPMsg address of heartbeat messages: the
+	// firmware taking one off a vring feeds the watchdog. Zero disables
+	// heartbeats
+	HeartbeatEndpoint uint32
+	// Faults is the fault-injection timeline, started when the remoteproc
+	// is created. More faults can be added with [Remoteproc.ScheduleFault]
+	Faults []Fault
+	// Chaos injects random faults
+	Chaos Chaos
+	// StateStore persists the state, firmware, recovery and coredump settings
+	// and counters of the remote processor, and restores them when it is
+	// created. A remote processor that was running comes back as
+	// StateAttached
+	StateStore *StateStore
+	// AdoptExisting reuses an instance directory left by an earlier run,
+	// e.g. with CleanupKeep. Without it, NewRemoteproc refuses to overwrite
+	// one with ErrInstanceExists
+	AdoptExisting bool
+	// Cleanup selects what Close removes, defaults to CleanupRemoveCreated
+	Cleanup CleanupPolicy
+	// KeepArtifactsOnFailure makes Close remove nothing when the remote
+	// processor failed, see [Remoteproc.Failed]
+	KeepArtifactsOnFailure bool
 }
 
 func (c Config) validate() error {
@@ -42,67 +210,333 @@ func (c Config) validate() error {
 	if c.Name == "" {
 		return errors.New("name name must be specified")
 	}
+	if c.DefaultFirmware != "" {
+		if err := validateFirmwareName(c.DefaultFirmware); err != nil {
+			return fmt.Errorf("invalid default firmware: %w", err)
+		}
+	}
+	if err := validateMemoryRegions(c.MemoryRegions); err != nil {
+		return err
+	}
+	if err := validateMailboxes(c.Mailboxes); err != nil {
+		return err
+	}
+	if c.Vrings && !c.DeviceMemory {
+		return errors.New("vrings require device memory")
+	}
+	if err := c.DoorbellFaults.validate(); err != nil {
+		return err
+	}
+	if c.SuspendLatency < 0 || c.ResumeLatency < 0 || c.AutosuspendDelay < 0 {
+		return errors.New("power management delays must not be negative")
+	}
+	if c.WatchdogTimeout < 0 {
+		return errors.New("watchdog timeout must not be negative")
+	}
+	if c.HeartbeatEndpoint != 0 && (c.WatchdogTimeout == 0 || !c.Vrings) {
+		return errors.New("heartbeats require a watchdog and vrings")
+	}
+	for _, fault := range c.Faults {
+		if err := fault.validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.LoadTime.validate(); err != nil {
+		return err
+	}
+	if err := c.Chaos.validate(); err != nil {
+		return err
+	}
+	if err := c.Cleanup.validate(); err != nil {
+		return err
+	}
 	return nil
 }
 
+func (c Config) withDefaults() Config {
+	if c.BootDelay == 0 {
+		c.BootDelay = defaultBootDelay
+	}
+	if c.KernelRelease == "" {
+		c.KernelRelease = defaultKernelRelease
+	}
+	if c.FirmwareFallbackTimeout == 0 {
+		c.FirmwareFallbackTimeout = defaultFallbackTimeout
+	}
+	if c.Compatible == "" {
+		c.Compatible = defaultCompatible
+	}
+	if c.AutoBoot && c.DefaultFirmware == "" {
+		c.DefaultFirmware = fmt.Sprintf("rproc-%s-fw", c.Name)
+	}
+	return c
+}
+
 // NewRemoteproc creates a new [Remoteproc].
 // The caller should call Close when finished to clean up resources.
 func NewRemoteproc(config Config) (*Remoteproc, error) {
 	if err := config.validate(); err != nil {
 		return nil, err
 	}
+	config = config.withDefaults()
 
 	r := &Remoteproc{
-		name:     config.Name,
-		fs:       NewFileSystemManager(config.RootDir, config.Index),
-		firmware: initialFirmware,
-		state:    initialState,
+		name:        config.Name,
+		fs:          NewFileSystemManager(config.RootDir, config.Index, config.KernelRelease),
+		firmware:    config.DefaultFirmware,
+		index:       config.Index,
+		autoBoot:    config.AutoBoot,
+		cdevEnabled: config.CharDevice,
+		state:       initialState,
+		bootDelay:   config.BootDelay,
+		loadTime:    config.LoadTime,
+		watcherConfig: dirwatcher.Config{
+			Backend:      dirwatcher.Backend(config.Watcher),
+			PollInterval: config.PollInterval,
+		},
+		fallbackConfig: fallbackConfig{
+			enabled: config.FirmwareFallback,
+			timeout: config.FirmwareFallbackTimeout,
+		},
+		deviceTree: deviceTreeConfig{
+			enabled:    config.DeviceTree,
+			compatible: config.Compatible,
+			mailboxes:  config.Mailboxes,
+		},
+		doorbellConfig: doorbellConfig{
+			enabled: config.Doorbell,
+			faults:  config.DoorbellFaults,
+		},
+		memoryRegions: config.MemoryRegions,
+		deviceMemory:  config.DeviceMemory,
+		vrings:        config.Vrings,
+		pm:            newPowerManagement(config.SuspendLatency, config.ResumeLatency, config.AutosuspendDelay),
+		watchdog: watchdog{
+			timeout:           config.WatchdogTimeout,
+			heartbeatEndpoint: config.HeartbeatEndpoint,
+		},
+		faults:   faultSchedule{start: time.Now()},
+		recovery: recoveryDisabled,
+		coredump: coredumpDefault,
+		store:    config.StateStore,
+		timers:   make(chan func()),
+		commands: make(chan command),
+
+		adoptDir:    config.AdoptExisting,
+		cleanupMode: config.Cleanup,
+		keepFailed:  config.KeepArtifactsOnFailure,
 	}
+	r.restore()
 
-	err := r.start()
+	err := r.start(config.Faults, config.Chaos)
 	if err != nil {
+		r.startFailed = true
 		r.Close()
 	}
 	return r, err
 }
 
-func (r *Remoteproc) start() error {
+// State returns the current state of the remote processor.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) State() State {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state
+}
+
+// Firmware returns the name of the currently selected firmware.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) Firmware() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.firmware
+}
+
+// Start boots the remote processor, as if "start" was written to its state
+// file. It returns once the boot has been initiated; use [Remoteproc.State] to
+// observe its completion.
+func (r *Remoteproc) Start() error {
+	return r.send(func() error { return r.handleCommand("start") })
+}
+
+// Stop shuts the remote processor down, cancelling any boot in progress.
+func (r *Remoteproc) Stop() error {
+	return r.send(func() error { return r.handleCommand("stop") })
+}
+
+// SetFirmware selects the firmware to boot, as if it was written to the
+// firmware file. Like the kernel, it refuses to do so unless the remote
+// processor is offline.
+func (r *Remoteproc) SetFirmware(name string) error {
+	return r.send(func() error { return r.changeFirmware(name) })
+}
+
+func (r *Remoteproc) send(run func() error) error {
+	if r.stopChan == nil {
+		return ErrClosed
+	}
+	cmd := command{run: run, result: make(chan error, 1)}
+	select {
+	case r.commands <- cmd:
+		return <-cmd.result
+	case <-r.loopDone:
+		return ErrClosed
+	}
+}
+
+// FirmwarePath returns where the firmware of the last successful load was
+// found. Firmware supplied through the sysfs fallback is reported as the data
+// file of its loader.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) FirmwarePath() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.firmwarePath
+}
+
+// FirmwareResolvedPath returns [Remoteproc.FirmwarePath] with all symbolic
+// links resolved, i.e. the file that was actually read.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) FirmwareResolvedPath() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.firmwareResolvedPath
+}
+
+// Index returns the N of /sys/class/remoteproc/remoteprocN.
+func (r *Remoteproc) Index() uint {
+	return r.index
+}
+
+// Name returns the name of the remote processor.
+func (r *Remoteproc) Name() string {
+	return r.name
+}
+
+// InstanceDir returns the path of /sys/class/remoteproc/remoteprocN.
+func (r *Remoteproc) InstanceDir() string {
+	return r.fs.InstanceDir()
+}
+
+func (r *Remoteproc) start(faults []Fault, chaos Chaos) error {
 	if err := r.bootstrapDirectoryStructure(); err != nil {
 		return fmt.Errorf("failed to bootstrap directory structure: %w", err)
 	}
 
-	watcher, err := dirwatcher.New(r.fs.InstanceDir())
+	watcher, err := dirwatcher.New(context.Background(), r.fs.InstanceDir(), r.watcherConfig)
 	if err != nil {
 		return fmt.Errorf("failed to setup directory watcher: %w", err)
 	}
 	r.watcher = watcher
 
+	if err := r.startPowerWatcher(); err != nil {
+		return err
+	}
+
+	if r.fallbackConfig.enabled {
+		if err := r.fs.BootstrapFirmwareFallback(r.fallbackConfig.timeout); err != nil {
+			return fmt.Errorf("failed to bootstrap firmware fallback: %w", err)
+		}
+	}
+
+	if err := r.fs.BootstrapDevDirectory(); err != nil {
+		return err
+	}
+	r.emitUevent(UeventAdd, "")
+	if r.state == StateAttached {
+		r.kmsg(kmsgInfo, "remote processor %s is now attached", r.name)
+	}
+
 	r.stopChan = make(chan struct{})
+	r.loopDone = make(chan struct{})
 	go r.loop()
 
+	if r.cdevEnabled {
+		cdev, err := newCdev(r.fs.CdevPath(), r)
+		if err != nil {
+			return err
+		}
+		r.cdev = cdev
+	}
+
+	if r.doorbellConfig.enabled {
+		if err := r.startDoorbell(); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Watching %s using %s", r.fs.InstanceDir(), watcher.Backend())
 	log.Printf("Remoteproc initialized at %s", r.fs.InstanceDir())
+
+	err = r.send(func() error {
+		r.applyFaults(faults)
+		r.applyChaos(chaos, 0)
+		r.persist()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if r.autoBoot {
+		return r.send(r.bootAutomatically)
+	}
+	return nil
+}
+
+// bootAutomatically emulates rproc_trigger_auto_boot. As in the kernel, a
+// failed auto boot leaves the remote processor offline but does not fail its
+// creation.
+func (r *Remoteproc) bootAutomatically() error {
+	if r.state == StateAttached {
+		return nil
+	}
+	log.Printf("Auto-booting with firmware %s", r.firmware)
+	if err := r.boot(); err != nil {
+		log.Printf("Auto-boot failed: %s", err)
+	}
 	return nil
 }
 
 func (r *Remoteproc) Close() error {
+	// Stop the loop before the watcher, so that the loop never mistakes the
+	// watcher shutting down for a reason to exit on its own.
+	if r.stopChan != nil {
+		close(r.stopChan)
+		<-r.loopDone
+		r.releaseCarveouts()
+		r.emitUevent(UeventRemove, "")
+	}
+
+	var cdevErr error
+	if r.cdev != nil {
+		cdevErr = r.cdev.Close()
+	}
+
+	var doorbellErr error
+	if r.doorbell != nil {
+		doorbellErr = r.doorbell.Close()
+	}
+
 	var watcherErr error
 	if r.watcher != nil {
 		watcherErr = r.watcher.Close()
 	}
-
-	if r.stopChan != nil {
-		close(r.stopChan)
+	if r.pm.watcher != nil {
+		watcherErr = errors.Join(watcherErr, r.pm.watcher.Close())
 	}
 
 	var fsErr error
 	if r.fs != nil {
-		fsErr = r.fs.Cleanup()
+		fsErr = r.cleanup()
 	}
 
-	return errors.Join(watcherErr, fsErr)
+	return errors.Join(cdevErr, doorbellErr, watcherErr, fsErr)
 }
 
 func (r *Remoteproc) bootstrapDirectoryStructure() error {
+	if err := r.fs.ClaimInstanceDirectory(r.adoptDir); err != nil {
+		return err
+	}
 	if err := r.fs.BootstrapDirectories(); err != nil {
 		return err
 	}
@@ -111,6 +545,9 @@ func (r *Remoteproc) bootstrapDirectoryStructure() error {
 		stateFileName:    r.state.String(),
 		firmwareFileName: r.firmware,
 		nameFileName:     r.name,
+		ueventFileName:   ueventFileContent(r.deviceUevent()),
+		recoveryFileName: r.recovery,
+		coredumpFileName: r.coredump,
 	}
 
 	for filename, content := range files {
@@ -119,24 +556,54 @@ func (r *Remoteproc) bootstrapDirectoryStructure() error {
 		}
 	}
 
+	if err := r.bootstrapPowerFiles(); err != nil {
+		return err
+	}
+
+	if r.deviceTree.enabled {
+		if err := r.bootstrapDeviceTree(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (r *Remoteproc) loop() {
+	defer close(r.loopDone)
 	for {
 		select {
 		case <-r.stopChan:
 			log.Printf("Remoteproc shutting down")
+			r.cancelBoot()
+			r.cancelFaults()
+			r.stopChaos()
 			return
+		case fn := <-r.timers:
+			fn()
+		case event, ok := <-r.fallback.Changes():
+			r.handleFallbackChange(event, ok)
+		case event, ok := <-r.pm.Changes():
+			if ok {
+				r.handlePowerWrite(event.Filename, event.Value)
+			}
+		case cmd := <-r.commands:
+			cmd.result <- cmd.run()
 		case event, ok := <-r.watcher.Changes():
 			if !ok {
 				return
 			}
 			switch event.Filename {
-			case "state":
+			case stateFileName:
 				r.handleStateChange(event.Value)
 			case firmwareFileName:
 				r.handleFirmwareChange(event.Value)
+			case ueventFileName:
+				r.handleUeventWrite(event.Value)
+			case recoveryFileName:
+				r.handleRecoveryWrite(event.Value)
+			case coredumpFileName:
+				r.handleCoredumpWrite(event.Value)
 			}
 		}
 	}
@@ -146,75 +613,328 @@ func (r *Remoteproc) handleStateChange(value string) {
 	if isStateSelfInflicted(value) {
 		return
 	}
+	if err := r.handleCommand(value); err != nil {
+		log.Printf("State change rejected: %s", err)
+	}
+}
 
+func (r *Remoteproc) handleCommand(value string) error {
 	log.Printf("State change request: %s -> %s", r.state, value)
 
 	switch value {
 	case "start":
-		if r.state == StateRunning {
-			log.Printf("Remoteproc is already running")
-			return
+		return r.boot()
+	case "stop":
+		return r.shutdown()
+	default:
+		return fmt.Errorf("invalid state command: %s", value)
+	}
+}
+
+func (r *Remoteproc) boot() error {
+	switch r.state {
+	case StateRunning, StateAttached:
+		return errors.New("remoteproc is already running")
+	case StateBooting:
+		return errors.New("remoteproc is already booting")
+	case StateSuspended:
+		return errors.New("remoteproc is suspended")
+	}
+
+	// A recovery reloads the firmware without rproc_boot and rproc_fw_boot,
+	// which print the boot messages.
+	if !r.recovering {
+		r.kmsg(kmsgInfo, "powering up %s", r.name)
+	}
+	if r.firmware == "" {
+		r.setState(StateCrashed)
+		return errors.New("cannot start: no firmware specified")
+	}
+
+	// An invalid name is refused before any lookup, so it never reaches the
+	// sysfs fallback either.
+	if err := validateFirmwareName(r.firmware); err != nil {
+		r.setState(r.state)
+		return fmt.Errorf("cannot start: %w", err)
+	}
+
+	image, err := r.loadFirmware()
+	if err != nil {
+		errno := kernelErrno(err, syscall.ENOENT)
+		r.kmsg(kmsgWarn, "Direct firmware load for %s failed with error %d", r.firmware, errno)
+		if !r.fallbackConfig.enabled {
+			r.kmsg(kmsgErr, "request_firmware failed: %d", errno)
+			r.setState(r.state)
+			return fmt.Errorf("cannot start: %w", err)
 		}
+		r.kmsg(kmsgWarn, "Falling back to sysfs fallback for: %s", r.firmware)
+	}
 
-		if r.firmware == "" {
-			log.Printf("Cannot start: no firmware specified")
-			r.state = StateCrashed
-			r.setState(StateCrashed)
-			return
+	r.bootID++
+	r.setState(StateBooting)
+	if err != nil {
+		log.Printf("%s, falling back to sysfs", err)
+		if err := r.startFallback(); err != nil {
+			r.failBoot(err)
+			return fmt.Errorf("cannot start: %w", err)
 		}
+		return nil
+	}
 
-		if err := r.fs.CheckFirmwareExists(r.firmware); err != nil {
-			log.Printf("Cannot start: %s", err)
-			r.setState(r.state)
-			return
+	if err := r.firmwareLoaded(image); err != nil {
+		r.failBoot(err)
+		return fmt.Errorf("cannot start: %w", err)
+	}
+	return nil
+}
+
+// firmwareLoaded continues a boot once the firmware loader has handed over
+// the image.
+func (r *Remoteproc) firmwareLoaded(image firmwareImage) error {
+	if isCompressedFirmware(image.path) {
+		log.Printf("Starting remoteproc with firmware %s decompressed from %s (%d bytes)", image.name, image.path, len(image.data))
+	} else {
+		log.Printf("Starting remoteproc with firmware %s loaded from %s (%d bytes)", image.name, image.path, len(image.data))
+	}
+	if image.viaSymlink {
+		log.Printf("Firmware %s is a symbolic link to %s", image.path, image.resolvedPath)
+	}
+	r.image = image
+	r.setFirmwarePath(image.path, image.resolvedPath)
+	if !r.recovering {
+		r.kmsg(kmsgInfo, "Booting fw image %s, size %d", image.name, len(image.data))
+	}
+	load := r.loadTime.image(image.size)
+	if r.deviceMemory {
+		segments, err := r.loadELF(image)
+		if err != nil {
+			r.kmsg(kmsgErr, "Failed to load program segments: %d", kernelErrno(err, syscall.EINVAL))
+			return err
 		}
+		load += segments
+	}
+	if load > 0 {
+		log.Printf("Loading firmware %s takes %s", image.name, load)
+	}
+	r.scheduleBootCompletion(load)
+	return nil
+}
 
-		log.Printf("Starting remoteproc with firmware %s", r.firmware)
+func (r *Remoteproc) failBoot(err error) {
+	r.abandonBoot()
+	log.Printf("Failed to start remoteproc: %s", err)
+	if r.recovering {
+		r.setState(StateCrashed)
+		return
+	}
+	r.setState(StateOffline)
+}
 
-		// Simulate firmware loading delay
-		go func() {
-			select {
-			case <-time.After(100 * time.Millisecond):
-				log.Printf("Firmware %s started successfully", r.firmware)
-				r.setState(StateRunning)
-			case <-r.stopChan:
-				log.Printf("Firmware loading cancelled due to shutdown")
-			}
-		}()
+func (r *Remoteproc) shutdown() error {
+	if r.state == StateOffline {
+		return errors.New("remoteproc is already stopped")
+	}
+	if err := r.stopHangs(); err != nil {
+		return err
+	}
+	if r.state == StateBooting {
+		r.cancelBoot()
+	} else {
+		r.kmsg(kmsgInfo, "stopped remote processor %s", r.name)
+	}
 
-	case "stop":
-		if r.state == StateOffline {
-			log.Printf("Remoteproc is already stopped")
+	log.Printf("Stopping remoteproc")
+	r.setState(StateOffline)
+	return nil
+}
+
+// afterFunc runs fn on the loop goroutine once d elapses, unless the
+// remoteproc is closed first. Like any other message, fn may be delivered
+// after the timer was stopped, so it must check that it is still relevant.
+func (r *Remoteproc) afterFunc(d time.Duration, fn func()) *loopTimer {
+	timer := time.AfterFunc(d, func() {
+		select {
+		case r.timers <- fn:
+		case <-r.stopChan:
+		}
+	})
+	return &loopTimer{Timer: timer, deadline: time.Now().Add(d)}
+}
+
+// loopTimer is a timer of afterFunc, which remembers when it fires so that
+// pending timers can be reported.
+type loopTimer struct {
+	*time.Timer
+	deadline time.Time
+}
+
+// scheduleBootCompletion simulates the firmware loading delay, load being the
+// part of it that depends on the firmware. Each boot gets its own ID so that a
+// completion arriving after the boot was cancelled can be told apart from the
+// current one.
+func (r *Remoteproc) scheduleBootCompletion(load time.Duration) {
+	id := r.bootID
+	r.bootTimer = r.afterFunc(r.nextBootDelay()+load, func() {
+		if id != r.bootID || r.state != StateBooting {
 			return
 		}
+		r.bootTimer = nil
+		if r.bootFails() {
+			r.kmsg(kmsgErr, "can't start rproc %s: %d", r.name, -int(syscall.EIO))
+			r.failBoot(errors.New("boot failure injected"))
+			return
+		}
+		log.Printf("Firmware %s started successfully", r.firmware)
+		r.kmsg(kmsgInfo, "remote processor %s is now up", r.name)
+		r.setState(StateRunning)
+	})
+}
 
-		log.Printf("Stopping remoteproc")
-		r.setState(StateOffline)
+func (r *Remoteproc) cancelBoot() {
+	if r.bootTimer == nil && r.fallback == nil {
+		return
+	}
+	r.abandonBoot()
+	log.Printf("Firmware %s loading cancelled", r.firmware)
+}
 
-	default:
-		log.Printf("Invalid state command: %s", value)
+// abandonBoot releases everything a boot in progress holds on to, and makes
+// sure none of its pending timers take effect.
+func (r *Remoteproc) abandonBoot() {
+	if r.bootTimer != nil {
+		r.bootTimer.Stop()
+		r.bootTimer = nil
 	}
+	r.closeFallback()
+	r.bootID++
 }
 
-func (r *Remoteproc) setState(state state) {
+func (r *Remoteproc) setState(state State) {
+	if !state.isUp() {
+		r.cdevOwner = nil
+	}
+	if state == StateOffline {
+		r.releaseCarveouts()
+	}
+	// The file is written first, so that sysfs already shows a state the
+	// API reports.
+	if state != StateBooting {
+		if err := r.fs.WriteInstanceFile(stateFileName, state.String()); err != nil {
+			log.Printf("Failed to update state: %s", err)
+		}
+	}
+	if state != StateBooting {
+		r.recovering = false
+	}
+	r.mu.Lock()
+	previous := r.state
 	r.state = state
-	r.fs.WriteInstanceFile(stateFileName, state.String())
+	switch {
+	case state != StateCrashed:
+		r.crashReason = CrashNone
+	case r.crashReason == CrashNone:
+		r.crashReason = CrashFatalError
+	}
+	r.mu.Unlock()
+	r.updatePowerState(state)
+	r.updateWatchdog(state)
+	r.updateFaults(previous, state)
+	r.countTransition(previous, state)
+	r.persist()
+}
+
+func (r *Remoteproc) setFirmware(firmware string) {
+	r.mu.Lock()
+	r.firmware = firmware
+	r.mu.Unlock()
+}
+
+func (r *Remoteproc) setFirmwarePath(path, resolvedPath string) {
+	r.mu.Lock()
+	r.firmwarePath = path
+	r.firmwareResolvedPath = resolvedPath
+	r.mu.Unlock()
 }
 
 func (r *Remoteproc) handleFirmwareChange(value string) {
-	if r.state == StateRunning {
-		log.Printf("Cannot change firmware while Remoteproc is %s", r.state)
-		r.fs.WriteInstanceFile(firmwareFileName, r.firmware)
+	if value == r.firmware {
 		return
 	}
-	r.firmware = value
-	log.Printf("Firmware set to %s", value)
+	if err := r.changeFirmware(value); err != nil {
+		log.Printf("Firmware change rejected: %s", err)
+		r.fs.WriteInstanceFile(firmwareFileName, r.firmware)
+	}
+}
+
+func (r *Remoteproc) changeFirmware(name string) error {
+	if r.state.isUp() {
+		return fmt.Errorf("cannot change firmware while remoteproc is %s: %w", r.state, syscall.EBUSY)
+	}
+	if err := validateFirmwareName(name); err != nil {
+		return err
+	}
+	r.setFirmware(name)
+	r.fs.WriteInstanceFile(firmwareFileName, name)
+	r.persist()
+	log.Printf("Firmware set to %s", name)
+	return nil
 }
 
 func isStateSelfInflicted(value string) bool {
 	switch value {
-	case StateRunning.String(), StateCrashed.String(), StateOffline.String():
+	case StateRunning.String(), StateCrashed.String(), StateOffline.String(), StateSuspended.String(), StateAttached.String():
 		return true
 	}
 	return false
 }
+
+// handleUeventWrite emulates writing an action to a device's uevent file,
+// which makes the kernel emit a synthetic uevent for it.
+func (r *Remoteproc) handleUeventWrite(value string) {
+	content := ueventFileContent(r.deviceUevent())
+	if value == strings.TrimSpace(content) {
+		return
+	}
+	fields := strings.Fields(value)
+	uuid := "0"
+	if len(fields) > 1 {
+		uuid = fields[1]
+	}
+	if isSynthUeventAction(fields[0]) {
+		r.emitUevent(UeventAction(fields[0]), uuid)
+	} else {
+		log.Printf("Invalid uevent action: %s", fields[0])
+	}
+	r.fs.WriteInstanceFile(ueventFileName, content)
+}
+
+func (r *Remoteproc) emitUevent(action UeventAction, synthUUID string) {
+	event := r.deviceUevent()
+	event.Action = action
+	event.SynthUUID = synthUUID
+	r.logUevent(event)
+}
+
+// deviceUevent returns the environment common to all uevents of the device.
+func (r *Remoteproc) deviceUevent() Uevent {
+	event := Uevent{
+		DevPath:   r.fs.DevPath(),
+		Subsystem: ueventSubsystem,
+		DevType:   ueventDevType,
+	}
+	if r.cdevEnabled {
+		event.Major = strconv.Itoa(cdevMajor)
+		event.Minor = strconv.FormatUint(uint64(r.index), 10)
+		event.DevName = r.fs.InstanceName()
+	}
+	return event
+}
+
+func (r *Remoteproc) logUevent(event Uevent) {
+	event, err := appendUevent(r.fs.UeventLogPath(), event)
+	if err != nil {
+		log.Printf("Failed to emit uevent: %v", err)
+		return
+	}
+	log.Printf("Emitted uevent %s %s (SEQNUM=%s)", event.Action, event.DevPath, event.SeqNum)
+}
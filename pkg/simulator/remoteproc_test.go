@@ -1,10 +1,16 @@
 package simulator_test
 
 import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/arm/remoteproc-simulator/pkg/simulator"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConfigValidation(t *testing.T) {
@@ -24,3 +30,363 @@ func TestConfigValidation(t *testing.T) {
 		assert.ErrorContains(t, err, "name must be specified")
 	})
 }
+
+func TestConcurrentAccess(t *testing.T) {
+	t.Run("accessors are safe to call while the remoteproc changes state", func(t *testing.T) {
+		root := t.TempDir()
+		r := newTestRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0"})
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+
+		stopReading := make(chan struct{})
+		var readers sync.WaitGroup
+		for range 4 {
+			readers.Go(func() {
+				for {
+					select {
+					case <-stopReading:
+						return
+					default:
+						_ = r.State()
+						_ = r.Firmware()
+					}
+				}
+			})
+		}
+
+		writeInstanceFile(t, r, "firmware", "some-firmware.elf")
+		writeInstanceFile(t, r, "state", "start")
+		requireState(t, r, simulator.StateRunning)
+		writeInstanceFile(t, r, "state", "stop")
+		requireState(t, r, simulator.StateOffline)
+
+		close(stopReading)
+		readers.Wait()
+		assert.Equal(t, "some-firmware.elf", r.Firmware())
+	})
+
+	t.Run("closing while firmware is loading does not race with boot completion", func(t *testing.T) {
+		root := t.TempDir()
+		r, err := simulator.NewRemoteproc(simulator.Config{RootDir: root, Name: "dsp0"})
+		require.NoError(t, err)
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", "some-firmware.elf"))
+
+		writeInstanceFile(t, r, "firmware", "some-firmware.elf")
+		require.Eventually(t, func() bool { return r.Firmware() == "some-firmware.elf" }, time.Second, time.Millisecond)
+		writeInstanceFile(t, r, "state", "start")
+
+		assert.NoError(t, r.Close())
+		// Give the pending boot timer a chance to fire after Close.
+		time.Sleep(200 * time.Millisecond)
+	})
+}
+
+func TestStartStop(t *testing.T) {
+	const bootDelay = 20 * time.Millisecond
+
+	t.Run("state is booting until firmware loading completes", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: bootDelay})
+
+		require.NoError(t, r.Start())
+
+		assert.Equal(t, simulator.StateBooting, r.State())
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "state"), "offline")
+		requireState(t, r, simulator.StateRunning)
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "state"), "running")
+	})
+
+	t.Run("stop cancels a boot in progress", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: bootDelay})
+
+		require.NoError(t, r.Start())
+		require.NoError(t, r.Stop())
+
+		assertNoPendingTimer(t, r, "boot completion")
+		assert.Equal(t, simulator.StateOffline, r.State())
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "state"), "offline")
+	})
+
+	t.Run("start is rejected while booting or running", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: bootDelay})
+
+		require.NoError(t, r.Start())
+		assert.ErrorContains(t, r.Start(), "already booting")
+		requireState(t, r, simulator.StateRunning)
+		assert.ErrorContains(t, r.Start(), "already running")
+	})
+
+	t.Run("final state matches the last command after concurrent interleaving", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: bootDelay})
+		writeFile := func(filename, content string) {
+			assert.NoError(t, os.WriteFile(filepath.Join(r.InstanceDir(), filename), []byte(content), 0644))
+		}
+		commands := []func(){
+			func() { r.Start() },
+			func() { r.Stop() },
+			func() { r.SetFirmware("some-firmware.elf") },
+			func() { writeFile("state", "start") },
+			func() { writeFile("state", "stop") },
+			func() { writeFile("firmware", "some-firmware.elf") },
+		}
+
+		// Writing the coredump file after the others and waiting for it to
+		// apply lets the writes before it through, as they are handled in
+		// the order they were made.
+		markers := []string{"inline", "disabled"}
+
+		for round := range 6 {
+			var wg sync.WaitGroup
+			for worker := range len(commands) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := range 20 {
+						commands[(worker+i)%len(commands)]()
+					}
+				}()
+			}
+			wg.Wait()
+			marker := markers[round%len(markers)]
+			writeFile("coredump", marker)
+			require.Eventually(t, func() bool { return r.Coredump() == marker }, time.Second, time.Millisecond)
+
+			// Both orders end with a state change, which rewrites the file.
+			want := simulator.StateOffline
+			if round%2 == 0 {
+				want = simulator.StateRunning
+				r.Stop()
+				r.Start()
+			} else {
+				r.Start()
+				r.Stop()
+			}
+
+			requireState(t, r, want)
+			assertNoPendingTimer(t, r, "boot completion")
+			assertFileContent(t, filepath.Join(r.InstanceDir(), "state"), want.String())
+		}
+	})
+
+	t.Run("commands fail once the remoteproc is closed", func(t *testing.T) {
+		r, err := simulator.NewRemoteproc(simulator.Config{RootDir: t.TempDir(), Name: "dsp0"})
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+
+		assert.ErrorIs(t, r.Start(), simulator.ErrClosed)
+		assert.ErrorIs(t, r.Stop(), simulator.ErrClosed)
+	})
+}
+
+func TestFirmwareSelection(t *testing.T) {
+	t.Run("it refuses names that traverse out of the firmware directories", func(t *testing.T) {
+		r := newTestRemoteproc(t, simulator.Config{RootDir: t.TempDir(), Name: "dsp0"})
+
+		err := r.SetFirmware("../../etc/passwd")
+
+		assert.ErrorIs(t, err, syscall.EINVAL)
+		assert.Equal(t, "", r.Firmware())
+	})
+
+	t.Run("invalid names written to sysfs are reverted", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0"})
+
+		writeInstanceFile(t, r, "firmware", "../../etc/passwd")
+
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			content, err := os.ReadFile(filepath.Join(r.InstanceDir(), "firmware"))
+			if assert.NoError(c, err) {
+				assert.Equal(c, "some-firmware.elf", string(content))
+			}
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, "some-firmware.elf", r.Firmware())
+	})
+
+	t.Run("it refuses to change firmware unless offline", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0"})
+		require.NoError(t, r.Start())
+
+		err := r.SetFirmware("other-firmware.elf")
+
+		assert.ErrorIs(t, err, syscall.EBUSY)
+	})
+
+	t.Run("the selected firmware is written to sysfs", func(t *testing.T) {
+		r := newTestRemoteproc(t, simulator.Config{RootDir: t.TempDir(), Name: "dsp0"})
+
+		require.NoError(t, r.SetFirmware("vendor/fw.elf"))
+
+		assert.Equal(t, "vendor/fw.elf", r.Firmware())
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "firmware"), "vendor/fw.elf")
+	})
+}
+
+func TestAutoBoot(t *testing.T) {
+	newRootWithFirmware := func(t *testing.T, name string) string {
+		root := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "lib", "firmware"), 0755))
+		createFirmwareFile(t, filepath.Join(root, "lib", "firmware", name))
+		return root
+	}
+
+	t.Run("default firmware is selected without booting", func(t *testing.T) {
+		r := newTestRemoteproc(t, simulator.Config{RootDir: t.TempDir(), Name: "dsp0", DefaultFirmware: "fw.elf"})
+
+		assert.Equal(t, "fw.elf", r.Firmware())
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "firmware"), "fw.elf")
+		assert.Equal(t, simulator.StateOffline, r.State())
+	})
+
+	t.Run("it boots the default firmware on creation", func(t *testing.T) {
+		root := newRootWithFirmware(t, "fw.elf")
+
+		r := newTestRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0", DefaultFirmware: "fw.elf", AutoBoot: true})
+
+		requireState(t, r, simulator.StateRunning)
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "state"), "running")
+	})
+
+	t.Run("it falls back to the kernel's default firmware name", func(t *testing.T) {
+		root := newRootWithFirmware(t, "rproc-dsp0-fw")
+
+		r := newTestRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0", AutoBoot: true})
+
+		assert.Equal(t, "rproc-dsp0-fw", r.Firmware())
+		requireState(t, r, simulator.StateRunning)
+	})
+
+	t.Run("a failed auto boot leaves the remote processor offline", func(t *testing.T) {
+		root := t.TempDir()
+		r, err := simulator.NewRemoteproc(simulator.Config{RootDir: root, Name: "dsp0", DefaultFirmware: "missing.elf", AutoBoot: true})
+		require.NoError(t, err)
+		t.Cleanup(func() { r.Close() })
+
+		assert.Contains(t, readKmsg(t, root), "3;remoteproc remoteproc0: request_firmware failed: -2")
+		assert.Equal(t, simulator.StateOffline, r.State())
+	})
+
+	t.Run("an invalid default firmware is rejected", func(t *testing.T) {
+		_, err := simulator.NewRemoteproc(simulator.Config{RootDir: t.TempDir(), Name: "dsp0", DefaultFirmware: "../fw.elf"})
+
+		assert.ErrorIs(t, err, syscall.EINVAL)
+	})
+}
+
+func newTestRemoteproc(t *testing.T, config simulator.Config) *simulator.Remoteproc {
+	t.Helper()
+	r, err := simulator.NewRemoteproc(config)
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// newRootedRemoteproc creates a remoteproc in a root directory of its own,
+// which it returns as well. Unless config says otherwise, it is named dsp0 and
+// boots in 10ms.
+func newRootedRemoteproc(t *testing.T, config simulator.Config) (*simulator.Remoteproc, string) {
+	t.Helper()
+	if config.RootDir == "" {
+		config.RootDir = t.TempDir()
+	}
+	if config.Name == "" {
+		config.Name = "dsp0"
+	}
+	if config.BootDelay == 0 {
+		config.BootDelay = 10 * time.Millisecond
+	}
+	return newTestRemoteproc(t, config), config.RootDir
+}
+
+// bootFirmware installs firmware as fw.elf in the firmware directory under
+// root, selects it and starts r.
+func bootFirmware(t *testing.T, r *simulator.Remoteproc, root string, firmware []byte) error {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "lib", "firmware", "fw.elf"), firmware, 0644))
+	require.NoError(t, r.SetFirmware("fw.elf"))
+	return r.Start()
+}
+
+func createFirmwareFile(t *testing.T, path string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(""), 0644))
+}
+
+func writeInstanceFile(t *testing.T, r *simulator.Remoteproc, filename, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(r.InstanceDir(), filename), []byte(content), 0644))
+}
+
+func requireState(t *testing.T, r *simulator.Remoteproc, want simulator.State) {
+	t.Helper()
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.Equal(c, want, r.State())
+	}, time.Second, 10*time.Millisecond)
+}
+
+// newTestRemoteprocWithFirmware creates a remoteproc, in a fresh root
+// directory unless config has one, with some-firmware.elf already selected.
+func newTestRemoteprocWithFirmware(t *testing.T, config simulator.Config) *simulator.Remoteproc {
+	t.Helper()
+	if config.RootDir == "" {
+		config.RootDir = t.TempDir()
+	}
+	r := newTestRemoteproc(t, config)
+	createFirmwareFile(t, filepath.Join(config.RootDir, "lib", "firmware", "some-firmware.elf"))
+	selectFirmware(t, r, "some-firmware.elf")
+	return r
+}
+
+// newRunningRemoteproc creates a remoteproc with firmware and boots it, named
+// dsp0 and booting in a millisecond unless config says otherwise.
+func newRunningRemoteproc(t *testing.T, config simulator.Config) *simulator.Remoteproc {
+	t.Helper()
+	if config.Name == "" {
+		config.Name = "dsp0"
+	}
+	if config.BootDelay == 0 {
+		config.BootDelay = time.Millisecond
+	}
+	r := newTestRemoteprocWithFirmware(t, config)
+	requireRunning(t, r)
+	return r
+}
+
+// requireRunning starts r and waits until it runs.
+func requireRunning(t *testing.T, r *simulator.Remoteproc) {
+	t.Helper()
+	require.NoError(t, r.Start())
+	requireState(t, r, simulator.StateRunning)
+}
+
+func selectFirmware(t *testing.T, r *simulator.Remoteproc, name string) {
+	t.Helper()
+	writeInstanceFile(t, r, "firmware", name)
+	require.Eventually(t, func() bool { return r.Firmware() == name }, time.Second, time.Millisecond)
+}
+
+// assertNoPendingTimer asserts that no timer with the given name is pending,
+// so that nothing it would do can happen any more.
+func assertNoPendingTimer(t *testing.T, r *simulator.Remoteproc, name string) {
+	t.Helper()
+	snapshot, err := r.Snapshot()
+	require.NoError(t, err)
+	for _, timer := range snapshot.PendingTimers {
+		assert.NotEqual(t, name, timer.Name)
+	}
+}
+
+func requireInstanceFile(t *testing.T, r *simulator.Remoteproc, filename, want string) {
+	t.Helper()
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		content, err := os.ReadFile(filepath.Join(r.InstanceDir(), filename))
+		if assert.NoError(c, err) {
+			assert.Equal(c, want, string(content))
+		}
+	}, time.Second, 10*time.Millisecond)
+}
+
+func assertFileContent(t *testing.T, path string, want string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, want, string(got))
+	}
+}
@@ -0,0 +1,198 @@
+package simulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Scenario describes the remote processors to simulate and their
+// fault-injection timelines. It is read from a JSON file such as:
+//
+//	{
+//	  "instances": [
+//	    {
+//	      "index": 0,
+//	      "name": "dsp0",
+//	      "firmware": "hello.elf",
+//	      "autoBoot": true,
+//	      "faults": [
+//	        {"kind": "crash", "on": "running", "after": "5s"},
+//	        {"kind": "boot-failure", "on": "crashed"}
+//	      ]
+//	    }
+//	  ]
+//	}
+type Scenario struct {
+	Instances []ScenarioInstance `json:"instances"`
+}
+
+// ScenarioInstance is a remote processor of a [Scenario]. Fields left out
+// keep the value of the base configuration they are applied to.
+type ScenarioInstance struct {
+	Index           uint              `json:"index"`
+	Name            string            `json:"name"`
+	Firmware        string            `json:"firmware,omitempty"`
+	AutoBoot        bool              `json:"autoBoot,omitempty"`
+	BootDelay       Duration          `json:"bootDelay,omitempty"`
+	LoadTime        *ScenarioLoadTime `json:"loadTime,omitempty"`
+	WatchdogTimeout Duration          `json:"watchdogTimeout,omitempty"`
+	Faults          []ScenarioFault   `json:"faults,omitempty"`
+	Chaos           *ScenarioChaos    `json:"chaos,omitempty"`
+}
+
+// ScenarioLoadTime is the JSON form of a [LoadTime], overriding that of the
+// base configuration.
+type ScenarioLoadTime struct {
+	BytesPerSecond        uint64   `json:"bytesPerSecond,omitempty"`
+	SegmentBytesPerSecond uint64   `json:"segmentBytesPerSecond,omitempty"`
+	SegmentOverhead       Duration `json:"segmentOverhead,omitempty"`
+}
+
+// ScenarioChaos sets the chaos rates of an instance, overriding those of the
+// base configuration. The seed and log stay those of the base configuration.
+type ScenarioChaos struct {
+	CrashRate       float64  `json:"crashRate,omitempty"`
+	BootFailureRate float64  `json:"bootFailureRate,omitempty"`
+	SlowBootRate    float64  `json:"slowBootRate,omitempty"`
+	StopFailureRate float64  `json:"stopFailureRate,omitempty"`
+	SlowBootDelay   Duration `json:"slowBootDelay,omitempty"`
+}
+
+// ScenarioFault is the JSON form of a [Fault].
+type ScenarioFault struct {
+	Kind  FaultKind `json:"kind"`
+	On    string    `json:"on,omitempty"`
+	After Duration  `json:"after,omitempty"`
+	Count int       `json:"count,omitempty"`
+	Delay Duration  `json:"delay,omitempty"`
+}
+
+// Duration is a time.Duration written as a string like "1.5s" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"1.5s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadScenario reads and checks a scenario file.
+func LoadScenario(path string) (Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Scenario{}, fmt.Errorf("failed to read scenario: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var scenario Scenario
+	if err := decoder.Decode(&scenario); err != nil {
+		return Scenario{}, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	if err := scenario.validate(); err != nil {
+		return Scenario{}, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	return scenario, nil
+}
+
+func (s Scenario) validate() error {
+	if len(s.Instances) == 0 {
+		return errors.New("no instances")
+	}
+	indexes := map[uint]bool{}
+	for _, instance := range s.Instances {
+		if instance.Name == "" {
+			return fmt.Errorf("instance %d: name must be specified", instance.Index)
+		}
+		if indexes[instance.Index] {
+			return fmt.Errorf("instance %d: index used twice", instance.Index)
+		}
+		indexes[instance.Index] = true
+		for _, fault := range instance.Faults {
+			if err := fault.Fault().validate(); err != nil {
+				return fmt.Errorf("instance %d: %w", instance.Index, err)
+			}
+		}
+		if instance.Chaos != nil {
+			if err := instance.Chaos.apply(Chaos{}).validate(); err != nil {
+				return fmt.Errorf("instance %d: %w", instance.Index, err)
+			}
+		}
+		if instance.LoadTime != nil {
+			if err := instance.LoadTime.LoadTime().validate(); err != nil {
+				return fmt.Errorf("instance %d: %w", instance.Index, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Fault returns the fault f describes.
+func (f ScenarioFault) Fault() Fault {
+	return Fault{Kind: f.Kind, On: f.On, After: time.Duration(f.After), Count: f.Count, Delay: time.Duration(f.Delay)}
+}
+
+// Config applies the instance to a base configuration.
+func (i ScenarioInstance) Config(base Config) Config {
+	config := base
+	config.Index = i.Index
+	config.Name = i.Name
+	if i.Firmware != "" {
+		config.DefaultFirmware = i.Firmware
+	}
+	if i.AutoBoot {
+		config.AutoBoot = true
+	}
+	if i.BootDelay != 0 {
+		config.BootDelay = time.Duration(i.BootDelay)
+	}
+	if i.LoadTime != nil {
+		config.LoadTime = i.LoadTime.LoadTime()
+	}
+	if i.WatchdogTimeout != 0 {
+		config.WatchdogTimeout = time.Duration(i.WatchdogTimeout)
+	}
+	config.Faults = append([]Fault{}, base.Faults...)
+	for _, fault := range i.Faults {
+		config.Faults = append(config.Faults, fault.Fault())
+	}
+	if i.Chaos != nil {
+		config.Chaos = i.Chaos.apply(base.Chaos)
+	}
+	return config
+}
+
+// LoadTime returns the load time l describes.
+func (l ScenarioLoadTime) LoadTime() LoadTime {
+	return LoadTime{
+		BytesPerSecond:        l.BytesPerSecond,
+		SegmentBytesPerSecond: l.SegmentBytesPerSecond,
+		SegmentOverhead:       time.Duration(l.SegmentOverhead),
+	}
+}
+
+func (c ScenarioChaos) apply(base Chaos) Chaos {
+	chaos := base
+	chaos.CrashRate = c.CrashRate
+	chaos.BootFailureRate = c.BootFailureRate
+	chaos.SlowBootRate = c.SlowBootRate
+	chaos.StopFailureRate = c.StopFailureRate
+	if c.SlowBootDelay != 0 {
+		chaos.SlowBootDelay = time.Duration(c.SlowBootDelay)
+	}
+	return chaos
+}
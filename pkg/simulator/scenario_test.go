@@ -0,0 +1,80 @@
+package simulator_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScenario(t *testing.T) {
+	writeScenario := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "scenario.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	t.Run("it applies instances and their faults to a base configuration", func(t *testing.T) {
+		path := writeScenario(t, `{"instances": [
+			{"index": 0, "name": "dsp0", "firmware": "hello.elf", "bootDelay": "1s",
+			 "faults": [{"kind": "crash", "on": "running", "after": "5s"}]},
+			{"index": 1, "name": "dsp1"}
+		]}`)
+
+		scenario, err := simulator.LoadScenario(path)
+		require.NoError(t, err)
+		require.Len(t, scenario.Instances, 2)
+
+		base := simulator.Config{RootDir: "/tmp/root", Name: "base", BootDelay: time.Millisecond}
+		config := scenario.Instances[0].Config(base)
+		assert.Equal(t, "/tmp/root", config.RootDir)
+		assert.Equal(t, "dsp0", config.Name)
+		assert.Equal(t, "hello.elf", config.DefaultFirmware)
+		assert.Equal(t, time.Second, config.BootDelay)
+		assert.Equal(t, []simulator.Fault{{Kind: simulator.FaultCrash, On: "running", After: 5 * time.Second}}, config.Faults)
+		config = scenario.Instances[1].Config(base)
+		assert.Equal(t, uint(1), config.Index)
+		assert.Equal(t, time.Millisecond, config.BootDelay)
+	})
+
+	t.Run("it overrides the chaos rates of the base configuration", func(t *testing.T) {
+		path := writeScenario(t, `{"instances": [{"index": 0, "name": "dsp0", "chaos": {"crashRate": 0.5}}]}`)
+
+		scenario, err := simulator.LoadScenario(path)
+		require.NoError(t, err)
+
+		base := simulator.Config{Chaos: simulator.Chaos{Seed: 42, CrashRate: 1, BootFailureRate: 1}}
+		config := scenario.Instances[0].Config(base)
+		assert.Equal(t, simulator.Chaos{Seed: 42, CrashRate: 0.5}, config.Chaos)
+	})
+
+	t.Run("it sets the load time of an instance", func(t *testing.T) {
+		path := writeScenario(t, `{"instances": [{"index": 0, "name": "dsp0", "loadTime": {"bytesPerSecond": 1048576, "segmentOverhead": "2ms"}}]}`)
+
+		scenario, err := simulator.LoadScenario(path)
+		require.NoError(t, err)
+
+		config := scenario.Instances[0].Config(simulator.Config{LoadTime: simulator.LoadTime{SegmentBytesPerSecond: 1}})
+		assert.Equal(t, simulator.LoadTime{BytesPerSecond: 1048576, SegmentOverhead: 2 * time.Millisecond}, config.LoadTime)
+	})
+
+	t.Run("it refuses invalid scenarios", func(t *testing.T) {
+		for content, want := range map[string]string{
+			`{"instances": []}`:                                                      "no instances",
+			`{"instances": [{"index": 0}]}`:                                          "name must be specified",
+			`{"instances": [{"name": "a"}, {"name": "b"}]}`:                          "index used twice",
+			`{"instances": [{"name": "a", "bootDelay": 5}]}`:                         "duration",
+			`{"instances": [{"name": "a", "faults": [{"kind": "x"}]}]}`:              "unknown fault",
+			`{"instances": [{"name": "a", "typo": true}]}`:                           "unknown field",
+			`{"instances": [{"name": "a", "chaos": {"crashRate": -1}}]}`:             "must not be negative",
+			`{"instances": [{"name": "a", "loadTime": {"segmentOverhead": "-1s"}}]}`: "must not be negative",
+		} {
+			_, err := simulator.LoadScenario(writeScenario(t, content))
+			assert.ErrorContains(t, err, want, content)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"syscall"
+	"time"
+)
+
+// Shutdown stops the remote processor ahead of Close, so that observers see
+// it go offline before its files vanish. It fails right away if the stop
+// hangs, and after timeout if the remote processor doesn't get to stopping.
+// A remote processor that failed to stop counts as failed, see
+// [Remoteproc.Failed]. The state store keeps the state from before the
+// shutdown, so that the next run finds the remote processor as it was.
+func (r *Remoteproc) Shutdown(timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- r.send(func() error {
+			r.store = nil
+			if r.state == StateOffline {
+				return nil
+			}
+			log.Printf("Shutting down %s in state %s", r.name, r.state)
+			return r.shutdown()
+		})
+	}()
+	var err error
+	select {
+	case err = <-result:
+	case <-time.After(timeout):
+		err = fmt.Errorf("not stopped within %s: %w", timeout, syscall.ETIMEDOUT)
+	}
+	if errors.Is(err, ErrClosed) {
+		return err
+	}
+	if err != nil {
+		r.mu.Lock()
+		r.stopFailed = true
+		r.mu.Unlock()
+		return fmt.Errorf("failed to stop %s: %w", r.name, err)
+	}
+	log.Printf("Remoteproc %s stopped", r.name)
+	return nil
+}
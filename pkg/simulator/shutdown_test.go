@@ -0,0 +1,62 @@
+package simulator_test
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	t.Run("it stops a running remote processor before its files go", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{})
+
+		require.NoError(t, r.Shutdown(time.Second))
+
+		assert.Equal(t, simulator.StateOffline, r.State())
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "state"), "offline")
+		assert.False(t, r.Failed())
+	})
+
+	t.Run("it leaves an offline remote processor alone", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0"})
+
+		require.NoError(t, r.Shutdown(time.Second))
+
+		assert.Equal(t, simulator.StateOffline, r.State())
+	})
+
+	t.Run("it gives up on a hung stop without waiting for the timeout", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{})
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultStopHang}))
+		require.Eventually(t, func() bool { return len(r.FaultLog()) == 1 }, time.Second, time.Millisecond)
+
+		start := time.Now()
+		err := r.Shutdown(time.Minute)
+
+		assert.ErrorIs(t, err, syscall.ETIMEDOUT)
+		assert.ErrorContains(t, err, "failed to stop dsp0")
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, simulator.StateRunning, r.State())
+		assert.True(t, r.Failed())
+	})
+
+	t.Run("it keeps the state from before the shutdown in the state store", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		store, err := simulator.NewStateStore(path)
+		require.NoError(t, err)
+		r := newRunningRemoteproc(t, simulator.Config{StateStore: store})
+
+		require.NoError(t, r.Shutdown(time.Second))
+
+		restored, err := simulator.NewStateStore(path)
+		require.NoError(t, err)
+		instance, ok := restored.Instance(0)
+		require.True(t, ok)
+		assert.Equal(t, "running", instance.State)
+	})
+}
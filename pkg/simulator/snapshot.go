@@ -0,0 +1,96 @@
+package simulator
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Snapshot is the state of a remote processor at a point in time.
+type Snapshot struct {
+	Index         uint
+	Name          string
+	State         State
+	Firmware      string
+	CrashReason   CrashReason
+	RuntimeStatus string
+	Recovery      string
+	Coredump      string
+	Counters      Counters
+	// FaultsFired is the number of faults injected so far
+	FaultsFired int
+	// PendingTimers are the timers armed, soonest first
+	PendingTimers []PendingTimer
+}
+
+// PendingTimer is a timer armed by the remote processor, such as the
+// completion of a boot or the watchdog.
+type PendingTimer struct {
+	Name string
+	// In is how long until the timer fires
+	In time.Duration
+}
+
+// Snapshot takes a snapshot of the remote processor.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) Snapshot() (Snapshot, error) {
+	var snapshot Snapshot
+	err := r.send(func() error {
+		snapshot = r.snapshot()
+		return nil
+	})
+	return snapshot, err
+}
+
+func (r *Remoteproc) snapshot() Snapshot {
+	r.mu.RLock()
+	snapshot := Snapshot{
+		Index:         r.index,
+		Name:          r.name,
+		State:         r.state,
+		Firmware:      r.firmware,
+		CrashReason:   r.crashReason,
+		RuntimeStatus: r.pm.runtimeStatus,
+		Recovery:      r.recovery,
+		Coredump:      r.coredump,
+		Counters:      r.counters,
+		FaultsFired:   len(r.faults.records),
+	}
+	r.mu.RUnlock()
+
+	now := time.Now()
+	pending := func(name string, timer *loopTimer) {
+		if timer != nil {
+			snapshot.PendingTimers = append(snapshot.PendingTimers, PendingTimer{Name: name, In: timer.deadline.Sub(now)})
+		}
+	}
+	pending("boot completion", r.bootTimer)
+	if r.fallback != nil {
+		pending("firmware fallback timeout", r.fallback.timer)
+	}
+	pending("power transition", r.pm.transition)
+	pending("autosuspend", r.pm.autosuspend)
+	pending("watchdog", r.watchdog.timer)
+	for _, f := range r.faults.faults {
+		pending("fault "+f.Fault.String(), f.timer)
+	}
+	for _, stream := range r.chaos {
+		pending("chaos "+string(stream.kind), stream.timer)
+	}
+	slices.SortStableFunc(snapshot.PendingTimers, func(a, b PendingTimer) int {
+		return cmp.Compare(a.In, b.In)
+	})
+	return snapshot
+}
+
+func (s Snapshot) String() string {
+	timers := make([]string, len(s.PendingTimers))
+	for i, timer := range s.PendingTimers {
+		timers[i] = fmt.Sprintf("%s in %s", timer.Name, timer.In.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("remoteproc%d %s: state=%s firmware=%q crash=%q runtime=%s recovery=%s coredump=%s boots=%d crashes=%d faults=%d timers=[%s]",
+		s.Index, s.Name, s.State, s.Firmware, s.CrashReason, s.RuntimeStatus, s.Recovery, s.Coredump,
+		s.Counters.Boots, s.Counters.Crashes, s.FaultsFired, strings.Join(timers, ", "))
+}
@@ -0,0 +1,48 @@
+package simulator_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Run("it reports the state and the pending timers, soonest first", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{
+			Name:            "dsp0",
+			BootDelay:       time.Millisecond,
+			WatchdogTimeout: time.Minute,
+			Faults:          []simulator.Fault{{Kind: simulator.FaultCrash, After: time.Hour}},
+		})
+		require.NoError(t, r.Start())
+		requireState(t, r, simulator.StateRunning)
+
+		snapshot, err := r.Snapshot()
+
+		require.NoError(t, err)
+		assert.Equal(t, "dsp0", snapshot.Name)
+		assert.Equal(t, simulator.StateRunning, snapshot.State)
+		assert.Equal(t, "some-firmware.elf", snapshot.Firmware)
+		assert.Equal(t, simulator.Counters{Boots: 1}, snapshot.Counters)
+		require.Len(t, snapshot.PendingTimers, 2)
+		assert.Equal(t, "watchdog", snapshot.PendingTimers[0].Name)
+		assert.InDelta(t, time.Minute, snapshot.PendingTimers[0].In, float64(time.Second))
+		assert.Equal(t, "fault crash after 1h0m0s", snapshot.PendingTimers[1].Name)
+		assert.Contains(t, snapshot.String(), "remoteproc0 dsp0: state=running")
+	})
+
+	t.Run("it reports the boot in progress", func(t *testing.T) {
+		r := newTestRemoteprocWithFirmware(t, simulator.Config{Name: "dsp0", BootDelay: time.Hour})
+		require.NoError(t, r.Start())
+
+		snapshot, err := r.Snapshot()
+
+		require.NoError(t, err)
+		assert.Equal(t, simulator.StateBooting, snapshot.State)
+		require.Len(t, snapshot.PendingTimers, 1)
+		assert.Equal(t, "boot completion", snapshot.PendingTimers[0].Name)
+	})
+}
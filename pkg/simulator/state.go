@@ -1,14 +1,24 @@
 package simulator
 
-type state int
+type State int
 
 const (
-	StateOffline state = iota
+	StateOffline State = iota
 	StateRunning
 	StateCrashed
+	// StateBooting is only visible through the Go API. The kernel loads
+	// firmware synchronously within the sysfs write, so the state file is left
+	// untouched until the boot completes.
+	StateBooting
+	// StateSuspended is a running remote processor put to sleep by power
+	// management.
+	StateSuspended
+	// StateAttached is a remote processor found running when the simulator
+	// started, as the kernel finds a core booted by an earlier stage.
+	StateAttached
 )
 
-func (s state) String() string {
+func (s State) String() string {
 	switch s {
 	case StateOffline:
 		return "offline"
@@ -16,7 +26,29 @@ func (s state) String() string {
 		return "running"
 	case StateCrashed:
 		return "crashed"
+	case StateBooting:
+		return "booting"
+	case StateSuspended:
+		return "suspended"
+	case StateAttached:
+		return "attached"
 	default:
 		return "unknown"
 	}
 }
+
+// isUp tells whether firmware is loaded, i.e. the remote processor is
+// booting, running, suspended or attached.
+func (s State) isUp() bool {
+	return s == StateRunning || s == StateBooting || s == StateSuspended || s == StateAttached
+}
+
+// stateByName returns the state whose String is name.
+func stateByName(name string) (State, bool) {
+	for _, s := range []State{StateOffline, StateRunning, StateCrashed, StateBooting, StateSuspended, StateAttached} {
+		if s.String() == name {
+			return s, true
+		}
+	}
+	return 0, false
+}
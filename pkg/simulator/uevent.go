@@ -0,0 +1,153 @@
+package simulator
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ueventFileName    = "uevent"
+	ueventLogFileName = "uevents.jsonl"
+	ueventSubsystem   = "remoteproc"
+	ueventDevType     = "remoteproc"
+)
+
+type UeventAction string
+
+const (
+	UeventAdd    UeventAction = "add"
+	UeventRemove UeventAction = "remove"
+	UeventChange UeventAction = "change"
+)
+
+// Uevent is a single record of the uevent log. Fields are named after, and
+// formatted like, the environment the kernel attaches to a uevent.
+type Uevent struct {
+	Action    UeventAction `json:"ACTION"`
+	DevPath   string       `json:"DEVPATH"`
+	Subsystem string       `json:"SUBSYSTEM"`
+	// Major, Minor and DevName are set for devices with a character device
+	Major   string `json:"MAJOR,omitempty"`
+	Minor   string `json:"MINOR,omitempty"`
+	DevName string `json:"DEVNAME,omitempty"`
+	DevType string `json:"DEVTYPE,omitempty"`
+	// SynthUUID is set on events triggered by writing to a uevent file
+	SynthUUID string `json:"SYNTH_UUID,omitempty"`
+	// Firmware, Timeout and Async describe a request for the firmware_class
+	// sysfs fallback
+	Firmware string `json:"FIRMWARE,omitempty"`
+	Timeout  string `json:"TIMEOUT,omitempty"`
+	Async    string `json:"ASYNC,omitempty"`
+	SeqNum   string `json:"SEQNUM"`
+}
+
+// String formats the event the way `udevadm monitor --property` prints it.
+func (e Uevent) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ACTION=%s\nDEVPATH=%s\nSUBSYSTEM=%s\n", e.Action, e.DevPath, e.Subsystem)
+	for _, env := range [][2]string{
+		{"MAJOR", e.Major},
+		{"MINOR", e.Minor},
+		{"DEVNAME", e.DevName},
+		{"DEVTYPE", e.DevType},
+		{"SYNTH_UUID", e.SynthUUID},
+		{"FIRMWARE", e.Firmware},
+		{"TIMEOUT", e.Timeout},
+		{"ASYNC", e.Async},
+	} {
+		if env[1] != "" {
+			fmt.Fprintf(&b, "%s=%s\n", env[0], env[1])
+		}
+	}
+	fmt.Fprintf(&b, "SEQNUM=%s\n", e.SeqNum)
+	return b.String()
+}
+
+// ueventSeqNums holds the last SEQNUM handed out per uevent log. Like the
+// kernel's, the counter is shared by all devices, so that instances sharing a
+// root directory produce a single, strictly increasing sequence.
+var ueventSeqNums = struct {
+	sync.Mutex
+	last map[string]uint64
+}{last: map[string]uint64{}}
+
+// appendUevent assigns the next SEQNUM to event and appends it to the log at
+// path, one JSON object per line.
+func appendUevent(path string, event Uevent) (Uevent, error) {
+	ueventSeqNums.Lock()
+	defer ueventSeqNums.Unlock()
+
+	last, ok := ueventSeqNums.last[path]
+	if !ok {
+		last = lastLoggedSeqNum(path)
+	}
+	event.SeqNum = strconv.FormatUint(last+1, 10)
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return event, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return event, fmt.Errorf("failed to open uevent log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return event, fmt.Errorf("failed to write uevent log: %w", err)
+	}
+
+	ueventSeqNums.last[path] = last + 1
+	return event, nil
+}
+
+// lastLoggedSeqNum continues the sequence of a log left behind by an earlier run.
+func lastLoggedSeqNum(path string) uint64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var last uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event Uevent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if seqNum, err := strconv.ParseUint(event.SeqNum, 10, 64); err == nil && seqNum > last {
+			last = seqNum
+		}
+	}
+	return last
+}
+
+// ueventFileContent is what the kernel reports when reading a device's uevent
+// file: the device specific part of its environment.
+func ueventFileContent(event Uevent) string {
+	var b strings.Builder
+	for _, env := range [][2]string{
+		{"MAJOR", event.Major},
+		{"MINOR", event.Minor},
+		{"DEVNAME", event.DevName},
+		{"DEVTYPE", event.DevType},
+	} {
+		if env[1] != "" {
+			fmt.Fprintf(&b, "%s=%s\n", env[0], env[1])
+		}
+	}
+	return b.String()
+}
+
+func isSynthUeventAction(value string) bool {
+	switch value {
+	case "add", "remove", "change", "move", "online", "offline", "bind", "unbind":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package simulator_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUevents(t *testing.T) {
+	t.Run("an add event is emitted when the instance is created", func(t *testing.T) {
+		root := t.TempDir()
+
+		newTestRemoteproc(t, simulator.Config{RootDir: root, Index: 2, Name: "dsp0"})
+
+		assert.Equal(t, []simulator.Uevent{{
+			Action:    simulator.UeventAdd,
+			DevPath:   "/devices/platform/remoteproc2/remoteproc/remoteproc2",
+			Subsystem: "remoteproc",
+			DevType:   "remoteproc",
+			SeqNum:    "1",
+		}}, readUevents(t, root))
+	})
+
+	t.Run("a remove event is emitted when the instance is closed", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "run"), 0755))
+		r, err := simulator.NewRemoteproc(simulator.Config{RootDir: root, Name: "dsp0"})
+		require.NoError(t, err)
+
+		require.NoError(t, r.Close())
+
+		events := readUevents(t, root)
+		require.Len(t, events, 2)
+		assert.Equal(t, simulator.UeventRemove, events[1].Action)
+		assert.Equal(t, "2", events[1].SeqNum)
+	})
+
+	t.Run("instances sharing a root directory share the sequence number", func(t *testing.T) {
+		root := t.TempDir()
+
+		newTestRemoteproc(t, simulator.Config{RootDir: root, Index: 0, Name: "dsp0"})
+		newTestRemoteproc(t, simulator.Config{RootDir: root, Index: 1, Name: "dsp1"})
+
+		events := readUevents(t, root)
+		require.Len(t, events, 2)
+		assert.Equal(t, "/devices/platform/remoteproc0/remoteproc/remoteproc0", events[0].DevPath)
+		assert.Equal(t, "1", events[0].SeqNum)
+		assert.Equal(t, "/devices/platform/remoteproc1/remoteproc/remoteproc1", events[1].DevPath)
+		assert.Equal(t, "2", events[1].SeqNum)
+	})
+
+	t.Run("writing an action to the uevent file emits a synthetic event", func(t *testing.T) {
+		root := t.TempDir()
+		r := newTestRemoteproc(t, simulator.Config{RootDir: root, Name: "dsp0"})
+
+		writeInstanceFile(t, r, "uevent", "change")
+
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			events := readUevents(t, root)
+			if assert.Len(c, events, 2) {
+				assert.Equal(c, simulator.UeventChange, events[1].Action)
+				assert.Equal(c, "0", events[1].SynthUUID)
+			}
+		}, time.Second, 10*time.Millisecond)
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			content, err := os.ReadFile(filepath.Join(r.InstanceDir(), "uevent"))
+			if assert.NoError(c, err) {
+				assert.Equal(c, "DEVTYPE=remoteproc\n", string(content))
+			}
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func readUevents(t *testing.T, root string) []simulator.Uevent {
+	t.Helper()
+	f, err := os.Open(filepath.Join(root, "run", "uevents.jsonl"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	var events []simulator.Uevent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event simulator.Uevent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	return events
+}
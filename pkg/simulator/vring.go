@@ -0,0 +1,463 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+const (
+	// vdevBufferSize is the size of the buffers virtio_rpmsg_bus allocates,
+	// two per vring entry, from the buffer pool of a vdev.
+	vdevBufferSize = 512
+
+	vringDescSize     = 16
+	vringUsedElemSize = 8
+
+	vringDescFlagNext     = 1
+	vringDescFlagWrite    = 2
+	vringDescFlagIndirect = 4
+)
+
+// ErrNoVdevStatus is returned by [Vdev.Status] when the firmware's resource
+// table is not loaded in device memory.
+var ErrNoVdevStatus = errors.New("resource table is not loaded in device memory")
+
+// vringSize is the kernel's vring_size: the descriptor table and the
+// available ring, followed by the used ring at the next align boundary.
+func vringSize(num, align uint32) uint64 {
+	return vringUsedOffset(num, align) + 6 + vringUsedElemSize*uint64(num)
+}
+
+func vringUsedOffset(num, align uint32) uint64 {
+	availEnd := vringDescSize*uint64(num) + 2*(3+uint64(num))
+	return (availEnd + uint64(align) - 1) / uint64(align) * uint64(align)
+}
+
+func pageAlign(size uint64) uint64 {
+	return (size + pageSize - 1) &^ (pageSize - 1)
+}
+
+// deviceMemory is the set of carveouts the remote processor can reach, in
+// which the addresses of vring descriptors are looked up.
+type deviceMemory []*carveout
+
+func (m deviceMemory) lookup(da uint64, length int) (*carveout, int64, error) {
+	for _, c := range m {
+		if c.contains(da, uint64(length)) {
+			return c, int64(da - c.DeviceAddress), nil
+		}
+	}
+	return nil, 0, fmt.Errorf("no device memory at 0x%x (%d bytes): %w", da, length, syscall.EFAULT)
+}
+
+func (m deviceMemory) readAt(buf []byte, da uint64) error {
+	c, offset, err := m.lookup(da, len(buf))
+	if err != nil {
+		return err
+	}
+	_, err = c.file.ReadAt(buf, offset)
+	return err
+}
+
+func (m deviceMemory) writeAt(buf []byte, da uint64) error {
+	c, offset, err := m.lookup(da, len(buf))
+	if err != nil {
+		return err
+	}
+	_, err = c.file.WriteAt(buf, offset)
+	return err
+}
+
+func (m deviceMemory) u16(da uint64) (uint16, error) {
+	var b [2]byte
+	err := m.readAt(b[:], da)
+	return rscByteOrder.Uint16(b[:]), err
+}
+
+func (m deviceMemory) putU16(da uint64, v uint16) error {
+	var b [2]byte
+	rscByteOrder.PutUint16(b[:], v)
+	return m.writeAt(b[:], da)
+}
+
+// Vdev is a virtio device declared in the resource table, whose vrings and
+// buffer pool live in device memory. Its methods let Go code play the part of
+// the device, i.e. of the firmware, while the driver side runs against the
+// carveout backing files.
+type Vdev struct {
+	// Index numbers the vdevs of the resource table, as in vdev<Index>vring0
+	Index int
+	// ID is the virtio device ID, e.g. 7 for rpmsg
+	ID uint32
+	// Features are the device features (dfeatures)
+	Features uint32
+	Vrings   []*Vring
+	// Buffers is the pool the driver allocates buffers from
+	Buffers Carveout
+	// rscOffset is where the vdev resource lives in the resource table
+	rscOffset uint32
+	// statusAddress is the device address of the status byte in the loaded
+	// resource table, or zero when the table is not loaded
+	statusAddress uint64
+	memory        deviceMemory
+	// virtioIndex is the N of the virtioN device registered for the vdev
+	virtioIndex int
+}
+
+// Status returns the virtio status the driver wrote to the vdev resource of
+// the loaded resource table.
+func (v *Vdev) Status() (uint8, error) {
+	if v.statusAddress == 0 {
+		return 0, ErrNoVdevStatus
+	}
+	var b [1]byte
+	err := v.memory.readAt(b[:], v.statusAddress)
+	return b[0], err
+}
+
+// Vring is a split virtqueue in the legacy layout remoteproc uses, seen from
+// the device side. It is safe for use from multiple goroutines.
+type Vring struct {
+	Index         int
+	Num           uint32
+	Align         uint32
+	DeviceAddress uint64
+	// NotifyID is the rproc-wide ID the driver kicks the vring with
+	NotifyID uint32
+
+	memory deviceMemory
+	// popped is called with each chain taken off the vring
+	popped func(*VringChain)
+
+	mu sync.Mutex
+	// lastAvailIdx is the next entry of the available ring to consume
+	lastAvailIdx uint16
+	usedIdx      uint16
+}
+
+// VringBuffer is a buffer of a descriptor chain. The device reads buffers
+// that are not Writable, and fills the Writable ones.
+type VringBuffer struct {
+	Address  uint64
+	Len      uint32
+	Writable bool
+}
+
+// VringChain is a descriptor chain made available by the driver.
+type VringChain struct {
+	Head    uint16
+	Buffers []VringBuffer
+	memory  deviceMemory
+}
+
+func (v *Vring) availAddress() uint64 {
+	return v.DeviceAddress + vringDescSize*uint64(v.Num)
+}
+
+func (v *Vring) usedAddress() uint64 {
+	return v.DeviceAddress + vringUsedOffset(v.Num, v.Align)
+}
+
+// Pop takes the next chain the driver made available, or returns nil when
+// there is none.
+func (v *Vring) Pop() (*VringChain, error) {
+	chain, err := v.pop()
+	if chain != nil && v.popped != nil {
+		v.popped(chain)
+	}
+	return chain, err
+}
+
+func (v *Vring) pop() (*VringChain, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	availIdx, err := v.memory.u16(v.availAddress() + 2)
+	if err != nil {
+		return nil, err
+	}
+	if availIdx == v.lastAvailIdx {
+		return nil, nil
+	}
+	head, err := v.memory.u16(v.availAddress() + 4 + 2*uint64(v.lastAvailIdx%uint16(v.Num)))
+	if err != nil {
+		return nil, err
+	}
+	chain, err := v.readChain(head)
+	if err != nil {
+		return nil, err
+	}
+	v.lastAvailIdx++
+	return chain, nil
+}
+
+func (v *Vring) readChain(head uint16) (*VringChain, error) {
+	chain := &VringChain{Head: head, memory: v.memory}
+	index := head
+	for {
+		if uint32(index) >= v.Num {
+			return nil, fmt.Errorf("vring%d: descriptor %d out of range: %w", v.Index, index, syscall.EINVAL)
+		}
+		if len(chain.Buffers) == int(v.Num) {
+			return nil, fmt.Errorf("vring%d: descriptor chain %d loops: %w", v.Index, head, syscall.EINVAL)
+		}
+		var desc [vringDescSize]byte
+		if err := v.memory.readAt(desc[:], v.DeviceAddress+vringDescSize*uint64(index)); err != nil {
+			return nil, err
+		}
+		flags := rscByteOrder.Uint16(desc[12:])
+		if flags&vringDescFlagIndirect != 0 {
+			return nil, fmt.Errorf("vring%d: indirect descriptors are not supported: %w", v.Index, syscall.EINVAL)
+		}
+		chain.Buffers = append(chain.Buffers, VringBuffer{
+			Address:  rscByteOrder.Uint64(desc[0:]),
+			Len:      rscByteOrder.Uint32(desc[8:]),
+			Writable: flags&vringDescFlagWrite != 0,
+		})
+		if flags&vringDescFlagNext == 0 {
+			return chain, nil
+		}
+		index = rscByteOrder.Uint16(desc[14:])
+	}
+}
+
+// Read returns the content of the buffers of the chain the device reads.
+func (c *VringChain) Read() ([]byte, error) {
+	var data []byte
+	for _, buffer := range c.Buffers {
+		if buffer.Writable {
+			continue
+		}
+		b := make([]byte, buffer.Len)
+		if err := c.memory.readAt(b, buffer.Address); err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+	return data, nil
+}
+
+// Write fills the writable buffers of the chain with data, and returns
+// io.ErrShortBuffer when they are too small to hold all of it.
+func (c *VringChain) Write(data []byte) (int, error) {
+	written := 0
+	for _, buffer := range c.Buffers {
+		if !buffer.Writable || written == len(data) {
+			continue
+		}
+		n := min(int(buffer.Len), len(data)-written)
+		if err := c.memory.writeAt(data[written:written+n], buffer.Address); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	if written < len(data) {
+		return written, io.ErrShortBuffer
+	}
+	return written, nil
+}
+
+// Push returns a chain to the driver through the used ring, written being the
+// number of bytes the device wrote to it.
+func (v *Vring) Push(chain *VringChain, written uint32) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var elem [vringUsedElemSize]byte
+	rscByteOrder.PutUint32(elem[0:], uint32(chain.Head))
+	rscByteOrder.PutUint32(elem[4:], written)
+	slot := uint64(v.usedIdx % uint16(v.Num))
+	if err := v.memory.writeAt(elem[:], v.usedAddress()+4+vringUsedElemSize*slot); err != nil {
+		return err
+	}
+	// The index is only published once the element is in place.
+	if err := v.memory.putU16(v.usedAddress()+2, v.usedIdx+1); err != nil {
+		return err
+	}
+	v.usedIdx++
+	return nil
+}
+
+// Vdevs returns the virtio devices of the firmware that is running or
+// booting, when vrings are enabled.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) Vdevs() []*Vdev {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.vdevs)
+}
+
+// handleVdev is rproc_handle_vdev followed by rproc_alloc_vring: each vring
+// gets a vdev<N>vring<M> carveout, unless a memory region of that name was
+// registered, and an rproc-wide notify ID. Both are written back to the
+// resource table, as rp_find_vq does once the virtio driver probes.
+func (r *Remoteproc) handleVdev(table *resourceTable, offset uint32, avail int) error {
+	rsc, err := table.vdev(offset, avail)
+	if err != nil {
+		return err
+	}
+	vdev := &Vdev{Index: len(r.loadingVdevs), ID: rsc.id, Features: rsc.dfeatures, rscOffset: offset}
+
+	var bufferPoolSize uint64
+	for i, vringRsc := range rsc.vrings {
+		// vring_new_virtqueue refuses such rings, which the kernel only
+		// notices once the virtio driver probes.
+		if vringRsc.num&(vringRsc.num-1) != 0 || vringRsc.num > 0x8000 {
+			return fmt.Errorf("Bad virtqueue length %d: %w", vringRsc.num, syscall.ENOMEM)
+		}
+		size := pageAlign(vringSize(vringRsc.num, vringRsc.align))
+		c, err := r.vdevCarveout(fmt.Sprintf("vdev%dvring%d", vdev.Index, i), vringRsc.da, size)
+		if err != nil {
+			return err
+		}
+		vring := &Vring{
+			Index:         i,
+			Num:           vringRsc.num,
+			Align:         vringRsc.align,
+			DeviceAddress: c.DeviceAddress,
+			NotifyID:      r.nextNotifyID,
+		}
+		r.nextNotifyID++
+		table.putU32(vringRsc.offset, uint32(vring.DeviceAddress))
+		table.putU32(vringRsc.offset+12, vring.NotifyID)
+		vdev.Vrings = append(vdev.Vrings, vring)
+		bufferPoolSize += 2 * uint64(vringRsc.num) * vdevBufferSize
+	}
+
+	// Like rproc_add_virtio_dev, buffers come from the vdev<N>buffer memory
+	// region when there is one, whatever its size. Otherwise a pool large
+	// enough for virtio_rpmsg_bus stands in for the DMA allocator.
+	name := fmt.Sprintf("vdev%dbuffer", vdev.Index)
+	buffers := r.registeredCarveout(name)
+	if buffers == nil {
+		buffers = r.addVdevCarveout(name, fwRscAddrAny, pageAlign(bufferPoolSize))
+	}
+	vdev.Buffers = buffers.Carveout
+	log.Printf("vdev%d: virtio device %d with %d vrings", vdev.Index, vdev.ID, len(vdev.Vrings))
+
+	r.loadingVdevs = append(r.loadingVdevs, vdev)
+	return nil
+}
+
+// vdevCarveout returns the memory region registered under name, or adds a
+// carveout for it.
+func (r *Remoteproc) vdevCarveout(name string, da uint32, size uint64) (*carveout, error) {
+	if c := r.registeredCarveout(name); c != nil {
+		if err := checkCarveout(c, da, uint32(size)); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return r.addVdevCarveout(name, da, size), nil
+}
+
+func (r *Remoteproc) addVdevCarveout(name string, da uint32, size uint64) *carveout {
+	address := uint64(da)
+	if da == fwRscAddrAny {
+		address = r.allocateDeviceAddress(size)
+	}
+	c := &carveout{Carveout: Carveout{Name: name, DeviceAddress: address, Size: size}, rscOffset: -1}
+	r.addCarveout(c)
+	return c
+}
+
+// publishVdevs gives the vdevs access to the carveouts once they are
+// allocated, and only then makes them available through [Remoteproc.Vdevs].
+func (r *Remoteproc) publishVdevs(table *resourceTable, tableLoaded bool) {
+	memory := deviceMemory(slices.Clone(r.carveouts))
+	for _, vdev := range r.loadingVdevs {
+		vdev.memory = memory
+		if tableLoaded {
+			vdev.statusAddress = table.addr + uint64(vdev.rscOffset) + 20
+		}
+		for _, vring := range vdev.Vrings {
+			vring.memory = memory
+			if r.watchdog.heartbeatEndpoint != 0 {
+				vring.popped = r.heartbeatReceived
+			}
+		}
+	}
+	r.mu.Lock()
+	r.vdevs = r.loadingVdevs
+	r.mu.Unlock()
+	r.addVdevDevices(r.loadingVdevs)
+	r.loadingVdevs = nil
+}
+
+// virtioIDRpmsg is VIRTIO_ID_RPMSG.
+const virtioIDRpmsg = 7
+
+// virtioIndexes holds the virtio device indexes in use per uevent log. Like
+// the kernel's ida, they are shared by all devices, the lowest free index
+// being handed out first.
+var virtioIndexes = struct {
+	sync.Mutex
+	used map[string]map[int]bool
+}{used: map[string]map[int]bool{}}
+
+// addVdevDevices emits the events of the devices registered for each vdev:
+// the rproc-virtio platform device, its virtio device and, for rpmsg, the
+// rpmsg_ctrl device virtio_rpmsg_bus creates.
+func (r *Remoteproc) addVdevDevices(vdevs []*Vdev) {
+	path := r.fs.UeventLogPath()
+	virtioIndexes.Lock()
+	used := virtioIndexes.used[path]
+	if used == nil {
+		used = map[int]bool{}
+		virtioIndexes.used[path] = used
+	}
+	for _, vdev := range vdevs {
+		index := 0
+		for used[index] {
+			index++
+		}
+		used[index] = true
+		vdev.virtioIndex = index
+	}
+	virtioIndexes.Unlock()
+
+	for _, vdev := range vdevs {
+		for _, event := range r.vdevUevents(vdev) {
+			event.Action = UeventAdd
+			r.logUevent(event)
+		}
+	}
+}
+
+// removeVdevDevices emits the events of the devices of the vdevs going away,
+// children first.
+func (r *Remoteproc) removeVdevDevices(vdevs []*Vdev) {
+	for _, vdev := range slices.Backward(vdevs) {
+		for _, event := range slices.Backward(r.vdevUevents(vdev)) {
+			event.Action = UeventRemove
+			r.logUevent(event)
+		}
+	}
+
+	virtioIndexes.Lock()
+	defer virtioIndexes.Unlock()
+	for _, vdev := range vdevs {
+		delete(virtioIndexes.used[r.fs.UeventLogPath()], vdev.virtioIndex)
+	}
+}
+
+func (r *Remoteproc) vdevUevents(vdev *Vdev) []Uevent {
+	platformDevPath := fmt.Sprintf("%s/%s#vdev%dbuffer", r.fs.DevPath(), r.fs.InstanceName(), vdev.Index)
+	virtioDevPath := fmt.Sprintf("%s/virtio%d", platformDevPath, vdev.virtioIndex)
+	events := []Uevent{
+		{DevPath: platformDevPath, Subsystem: "platform"},
+		{DevPath: virtioDevPath, Subsystem: "virtio"},
+	}
+	if vdev.ID == virtioIDRpmsg {
+		events = append(events, Uevent{
+			DevPath:   fmt.Sprintf("%s/virtio%d.rpmsg_ctrl.0.0", virtioDevPath, vdev.virtioIndex),
+			Subsystem: "rpmsg",
+		})
+	}
+	return events
+}
@@ -0,0 +1,243 @@
+package simulator_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVrings(t *testing.T) {
+	const rscTableAddr = 0x3000_0000
+	rscRegion := simulator.MemoryRegion{Name: "rsc", Address: rscTableAddr, Size: 0x1000}
+	vringConfig := simulator.Config{DeviceMemory: true, Vrings: true, MemoryRegions: []simulator.MemoryRegion{rscRegion}}
+	vdevFirmware := func(table []byte) []byte {
+		return testELF{
+			segments:          []testSegment{{da: rscTableAddr, data: table}},
+			resourceTable:     table,
+			resourceTableAddr: rscTableAddr,
+		}.build()
+	}
+	rpmsgTable := func() []byte {
+		return testResourceTable(testVdevRsc(7, testVringRsc{da: 0xffffffff, align: 16, num: 4}, testVringRsc{da: 0xffffffff, align: 16, num: 4}))
+	}
+
+	t.Run("it lays out vrings and a buffer pool for each vdev", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, vringConfig)
+
+		require.NoError(t, bootFirmware(t, r, root, vdevFirmware(rpmsgTable())))
+
+		vdevs := r.Vdevs()
+		require.Len(t, vdevs, 1)
+		vdev := vdevs[0]
+		assert.Equal(t, uint32(7), vdev.ID)
+		require.Len(t, vdev.Vrings, 2)
+		assert.Equal(t, uint64(0x8000_0000), vdev.Vrings[0].DeviceAddress)
+		assert.Equal(t, uint32(0), vdev.Vrings[0].NotifyID)
+		assert.Equal(t, uint64(0x8000_1000), vdev.Vrings[1].DeviceAddress)
+		assert.Equal(t, uint32(1), vdev.Vrings[1].NotifyID)
+		assert.Equal(t, "vdev0buffer", vdev.Buffers.Name)
+		assert.Equal(t, uint64(0x8000_2000), vdev.Buffers.DeviceAddress)
+		assert.Equal(t, uint64(2*2*4*512), vdev.Buffers.Size)
+		assert.FileExists(t, filepath.Join(root, "run", "remoteproc0", "memory", "vdev0vring1@80001000"))
+	})
+
+	t.Run("it writes vring addresses and notify IDs to the loaded resource table", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, vringConfig)
+
+		require.NoError(t, bootFirmware(t, r, root, vdevFirmware(rpmsgTable())))
+
+		loaded := readMemory(t, filepath.Join(root, "run", "remoteproc0", "memory", "rsc@30000000"), 0, 0x100)
+		vring1 := 16 + 4 + 4 + 24 + 20
+		assert.Equal(t, uint32(0x8000_1000), binary.LittleEndian.Uint32(loaded[vring1:]), "da")
+		assert.Equal(t, uint32(1), binary.LittleEndian.Uint32(loaded[vring1+12:]), "notifyid")
+	})
+
+	t.Run("it uses memory regions registered for vrings", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, simulator.Config{
+			DeviceMemory:  true,
+			Vrings:        true,
+			MemoryRegions: []simulator.MemoryRegion{rscRegion, {Name: "vdev0vring0", Address: 0x4000_0000, Size: 0x1000}},
+		})
+
+		require.NoError(t, bootFirmware(t, r, root, vdevFirmware(rpmsgTable())))
+
+		assert.Equal(t, uint64(0x4000_0000), r.Vdevs()[0].Vrings[0].DeviceAddress)
+	})
+
+	t.Run("it refuses vrings that are not a power of two long", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, vringConfig)
+
+		err := bootFirmware(t, r, root, vdevFirmware(testResourceTable(testVdevRsc(7, testVringRsc{da: 0xffffffff, align: 16, num: 3}))))
+
+		assert.ErrorContains(t, err, "Bad virtqueue length 3")
+		assert.Empty(t, r.Vdevs())
+	})
+
+	t.Run("the device consumes and produces buffers the driver makes available", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, vringConfig)
+		require.NoError(t, bootFirmware(t, r, root, vdevFirmware(rpmsgTable())))
+		vdev := r.Vdevs()[0]
+		vring := vdev.Vrings[0]
+		driver := testVirtioDriver{t: t, vring: filepath.Join(root, "run", "remoteproc0", "memory", "vdev0vring0@80000000"), num: 4, align: 16}
+		buffers := filepath.Join(root, "run", "remoteproc0", "memory", "vdev0buffer@80002000")
+
+		chain, err := vring.Pop()
+		require.NoError(t, err)
+		assert.Nil(t, chain)
+
+		writeMemory(t, buffers, 0, []byte("ping"))
+		driver.setDesc(0, vdev.Buffers.DeviceAddress, 4, 1, 1)
+		driver.setDesc(1, vdev.Buffers.DeviceAddress+512, 512, 2, 0)
+		driver.makeAvailable(0)
+
+		chain, err = vring.Pop()
+		require.NoError(t, err)
+		require.NotNil(t, chain)
+		request, err := chain.Read()
+		require.NoError(t, err)
+		assert.Equal(t, []byte("ping"), request)
+		n, err := chain.Write([]byte("pong!"))
+		require.NoError(t, err)
+		require.NoError(t, vring.Push(chain, uint32(n)))
+
+		id, written := driver.used(0)
+		assert.Equal(t, uint32(0), id)
+		assert.Equal(t, uint32(5), written)
+		assert.Equal(t, uint16(1), driver.usedIdx())
+		assert.Equal(t, []byte("pong!"), readMemory(t, buffers, 512, 5))
+	})
+
+	t.Run("it reports the status the driver wrote", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, vringConfig)
+		require.NoError(t, bootFirmware(t, r, root, vdevFirmware(rpmsgTable())))
+
+		writeMemory(t, filepath.Join(root, "run", "remoteproc0", "memory", "rsc@30000000"), 16+4+4+20, []byte{0x0f})
+
+		status, err := r.Vdevs()[0].Status()
+		require.NoError(t, err)
+		assert.Equal(t, uint8(0x0f), status)
+	})
+
+	t.Run("it forgets vdevs when the remote processor stops", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, vringConfig)
+		require.NoError(t, bootFirmware(t, r, root, vdevFirmware(rpmsgTable())))
+		requireState(t, r, simulator.StateRunning)
+		vring := r.Vdevs()[0].Vrings[0]
+
+		require.NoError(t, r.Stop())
+
+		assert.Empty(t, r.Vdevs())
+		_, err := vring.Pop()
+		assert.Error(t, err)
+	})
+
+	t.Run("it emits uevents for the devices of each vdev", func(t *testing.T) {
+		r, root := newRootedRemoteproc(t, vringConfig)
+		require.NoError(t, bootFirmware(t, r, root, vdevFirmware(rpmsgTable())))
+		requireState(t, r, simulator.StateRunning)
+		require.NoError(t, r.Stop())
+
+		const platformDevPath = "/devices/platform/remoteproc0/remoteproc/remoteproc0/remoteproc0#vdev0buffer"
+		var events []string
+		for _, event := range readUevents(t, root)[1:] {
+			events = append(events, fmt.Sprintf("%s %s %s", event.Action, event.Subsystem, event.DevPath))
+		}
+		assert.Equal(t, []string{
+			"add platform " + platformDevPath,
+			"add virtio " + platformDevPath + "/virtio0",
+			"add rpmsg " + platformDevPath + "/virtio0/virtio0.rpmsg_ctrl.0.0",
+			"remove rpmsg " + platformDevPath + "/virtio0/virtio0.rpmsg_ctrl.0.0",
+			"remove virtio " + platformDevPath + "/virtio0",
+			"remove platform " + platformDevPath,
+		}, events)
+	})
+
+	t.Run("it requires device memory", func(t *testing.T) {
+		_, err := simulator.NewRemoteproc(simulator.Config{RootDir: t.TempDir(), Name: "dsp0", Vrings: true})
+
+		assert.ErrorContains(t, err, "vrings require device memory")
+	})
+}
+
+type testVringRsc struct {
+	da, align, num uint32
+}
+
+func testVdevRsc(id uint32, vrings ...testVringRsc) []byte {
+	le := binary.LittleEndian
+	rsc := le.AppendUint32(nil, 3) // RSC_VDEV
+	for _, v := range []uint32{id, 0, 0, 0, 0} {
+		rsc = le.AppendUint32(rsc, v)
+	}
+	rsc = append(rsc, 0, byte(len(vrings)), 0, 0)
+	for _, vring := range vrings {
+		for _, v := range []uint32{vring.da, vring.align, vring.num, 0, 0} {
+			rsc = le.AppendUint32(rsc, v)
+		}
+	}
+	return rsc
+}
+
+// testVirtioDriver plays the driver side of a vring through its backing file.
+type testVirtioDriver struct {
+	t          *testing.T
+	vring      string
+	num, align uint64
+	availIdx   uint16
+}
+
+func (d *testVirtioDriver) setDesc(index int, addr uint64, length uint32, flags, next uint16) {
+	le := binary.LittleEndian
+	desc := le.AppendUint64(nil, addr)
+	desc = le.AppendUint32(desc, length)
+	desc = le.AppendUint16(desc, flags)
+	desc = le.AppendUint16(desc, next)
+	writeMemory(d.t, d.vring, int64(16*index), desc)
+}
+
+func (d *testVirtioDriver) makeAvailable(head uint16) {
+	avail := int64(16 * d.num)
+	writeMemory(d.t, d.vring, avail+4+2*int64(uint64(d.availIdx)%d.num), binary.LittleEndian.AppendUint16(nil, head))
+	d.availIdx++
+	writeMemory(d.t, d.vring, avail+2, binary.LittleEndian.AppendUint16(nil, d.availIdx))
+}
+
+func (d *testVirtioDriver) usedOffset() int64 {
+	availEnd := 16*d.num + 2*(3+d.num)
+	return int64((availEnd + d.align - 1) / d.align * d.align)
+}
+
+func (d *testVirtioDriver) usedIdx() uint16 {
+	return binary.LittleEndian.Uint16(readMemory(d.t, d.vring, d.usedOffset()+2, 2))
+}
+
+func (d *testVirtioDriver) used(slot int64) (uint32, uint32) {
+	elem := readMemory(d.t, d.vring, d.usedOffset()+4+8*slot, 8)
+	return binary.LittleEndian.Uint32(elem), binary.LittleEndian.Uint32(elem[4:])
+}
+
+func writeMemory(t *testing.T, path string, offset int64, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteAt(data, offset)
+	require.NoError(t, err)
+}
+
+func readMemory(t *testing.T, path string, offset int64, n int) []byte {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	data := make([]byte, n)
+	_, err = f.ReadAt(data, offset)
+	require.NoError(t, err)
+	return data
+}
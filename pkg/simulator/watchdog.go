@@ -0,0 +1,173 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrNoWatchdog is returned by [Remoteproc.FeedWatchdog] on a [Remoteproc]
+// created without Config.WatchdogTimeout.
+var ErrNoWatchdog = errors.New("watchdog is not enabled")
+
+// CrashReason is why the remote processor crashed, as in enum
+// rproc_crash_type.
+type CrashReason int
+
+const (
+	// CrashNone is the reason of a remote processor that did not crash
+	CrashNone CrashReason = iota
+	CrashMMUFault
+	CrashWatchdog
+	CrashFatalError
+)
+
+func (c CrashReason) String() string {
+	switch c {
+	case CrashNone:
+		return "none"
+	case CrashMMUFault:
+		return "mmufault"
+	case CrashWatchdog:
+		return "watchdog"
+	case CrashFatalError:
+		return "fatal error"
+	default:
+		return "unknown"
+	}
+}
+
+// rpmsgHeaderSize is the size of struct rpmsg_hdr, the destination address
+// being at offset 4.
+const rpmsgHeaderSize = 16
+
+// watchdog emulates the watchdog of the remote processor, which the firmware
+// must feed while it runs. Its fields are owned by the remoteproc loop.
+type watchdog struct {
+	timeout time.Duration
+	// heartbeatEndpoint is the RPMsg address whose messages feed the
+	// watchdog, or zero
+	heartbeatEndpoint uint32
+	timer             *loopTimer
+	id                int
+}
+
+// CrashReason returns why the remote processor last crashed, or CrashNone
+// when it is not crashed.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) CrashReason() CrashReason {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.crashReason
+}
+
+// FeedWatchdog restarts the watchdog timeout, as the firmware does while it
+// is healthy. Feeding a remote processor that is not running has no effect.
+// It is safe to call from any goroutine.
+func (r *Remoteproc) FeedWatchdog() error {
+	return r.send(func() error {
+		if r.watchdog.timeout == 0 {
+			return ErrNoWatchdog
+		}
+		r.feedWatchdog()
+		return nil
+	})
+}
+
+// reportCrash is rproc_report_crash.
+func (r *Remoteproc) reportCrash(reason CrashReason) {
+	log.Printf("crash detected in %s: type %s", r.name, reason)
+	r.kmsg(kmsgErr, "crash detected in %s: type %s", r.name, reason)
+	r.mu.Lock()
+	r.crashReason = reason
+	r.mu.Unlock()
+	r.setState(StateCrashed)
+	r.kmsg(kmsgErr, "handling crash #%d in %s", r.counters.Crashes, r.name)
+	if r.coredump != coredumpDisabled {
+		r.addDevcoredump()
+	}
+}
+
+// devcdCounts holds the number of devcoredump devices created per uevent log,
+// numbering them across all devices as devcd_count does.
+var devcdCounts = struct {
+	sync.Mutex
+	count map[string]int
+}{count: map[string]int{}}
+
+// addDevcoredump emits the event of the devcoredump device dev_coredumpv
+// creates for the dump of a crash.
+func (r *Remoteproc) addDevcoredump() {
+	path := r.fs.UeventLogPath()
+	devcdCounts.Lock()
+	devcdCounts.count[path]++
+	index := devcdCounts.count[path]
+	devcdCounts.Unlock()
+
+	r.logUevent(Uevent{
+		Action:    UeventAdd,
+		DevPath:   fmt.Sprintf("/devices/virtual/devcoredump/devcd%d", index),
+		Subsystem: "devcoredump",
+	})
+}
+
+func (r *Remoteproc) feedWatchdog() {
+	if r.watchdog.timer != nil {
+		r.armWatchdog()
+	}
+}
+
+// updateWatchdog keeps the watchdog running while the firmware is. A
+// suspended remote processor does not run, so its watchdog starts over on
+// resume.
+func (r *Remoteproc) updateWatchdog(state State) {
+	if r.watchdog.timeout == 0 {
+		return
+	}
+	if state == StateRunning {
+		if r.watchdog.timer == nil {
+			r.armWatchdog()
+		}
+		return
+	}
+	r.stopWatchdog()
+}
+
+func (r *Remoteproc) armWatchdog() {
+	r.stopWatchdog()
+	id := r.watchdog.id
+	r.watchdog.timer = r.afterFunc(r.watchdog.timeout, func() {
+		if id != r.watchdog.id {
+			return
+		}
+		r.watchdog.timer = nil
+		log.Printf("Watchdog bite: firmware not fed for %s", r.watchdog.timeout)
+		r.reportCrash(CrashWatchdog)
+	})
+}
+
+func (r *Remoteproc) stopWatchdog() {
+	if r.watchdog.timer != nil {
+		r.watchdog.timer.Stop()
+		r.watchdog.timer = nil
+	}
+	r.watchdog.id++
+}
+
+// heartbeatReceived feeds the watchdog when the firmware takes an RPMsg
+// message addressed to the heartbeat endpoint off a vring.
+func (r *Remoteproc) heartbeatReceived(chain *VringChain) {
+	data, err := chain.Read()
+	if err != nil || len(data) < rpmsgHeaderSize {
+		return
+	}
+	if rscByteOrder.Uint32(data[4:]) != r.watchdog.heartbeatEndpoint {
+		return
+	}
+	r.send(func() error {
+		r.feedWatchdog()
+		return nil
+	})
+}
@@ -0,0 +1,145 @@
+package simulator_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arm/remoteproc-simulator/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchdog(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+
+	t.Run("it crashes the remote processor when the watchdog is not fed", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{WatchdogTimeout: timeout})
+
+		requireState(t, r, simulator.StateCrashed)
+		assert.Equal(t, simulator.CrashWatchdog, r.CrashReason())
+		assertFileContent(t, filepath.Join(r.InstanceDir(), "state"), "crashed")
+	})
+
+	t.Run("it adds a devcoredump device on a crash", func(t *testing.T) {
+		root := t.TempDir()
+		r := newRunningRemoteproc(t, simulator.Config{RootDir: root, WatchdogTimeout: timeout})
+
+		requireState(t, r, simulator.StateCrashed)
+
+		events := readUevents(t, root)
+		assert.Equal(t, simulator.Uevent{
+			Action:    simulator.UeventAdd,
+			DevPath:   "/devices/virtual/devcoredump/devcd1",
+			Subsystem: "devcoredump",
+			SeqNum:    "2",
+		}, events[len(events)-1])
+	})
+
+	t.Run("it adds no devcoredump device with coredumps disabled", func(t *testing.T) {
+		root := t.TempDir()
+		r := newRunningRemoteproc(t, simulator.Config{RootDir: root})
+		writeInstanceFile(t, r, "coredump", "disabled")
+		require.Eventually(t, func() bool { return r.Coredump() == "disabled" }, time.Second, time.Millisecond)
+
+		require.NoError(t, r.ScheduleFault(simulator.Fault{Kind: simulator.FaultCrash}))
+
+		requireState(t, r, simulator.StateCrashed)
+		assert.Len(t, readUevents(t, root), 1)
+	})
+
+	t.Run("it keeps running while the watchdog is fed", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{WatchdogTimeout: timeout})
+
+		for range 6 {
+			time.Sleep(timeout / 3)
+			require.NoError(t, r.FeedWatchdog())
+		}
+
+		assert.Equal(t, simulator.StateRunning, r.State())
+		assert.Equal(t, simulator.CrashNone, r.CrashReason())
+	})
+
+	t.Run("it stops the watchdog with the remote processor", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{WatchdogTimeout: timeout})
+
+		require.NoError(t, r.Stop())
+
+		assertNoPendingTimer(t, r, "watchdog")
+		assert.Equal(t, simulator.StateOffline, r.State())
+	})
+
+	t.Run("it stops the watchdog of a suspended remote processor", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{WatchdogTimeout: timeout})
+
+		require.NoError(t, r.Suspend())
+		requireState(t, r, simulator.StateSuspended)
+
+		assertNoPendingTimer(t, r, "watchdog")
+	})
+
+	t.Run("it clears the crash reason on restart", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{WatchdogTimeout: timeout})
+		requireState(t, r, simulator.StateCrashed)
+
+		require.NoError(t, r.Start())
+
+		assert.Equal(t, simulator.CrashNone, r.CrashReason())
+	})
+
+	t.Run("it refuses feeding a disabled watchdog", func(t *testing.T) {
+		r := newRunningRemoteproc(t, simulator.Config{})
+
+		assert.ErrorIs(t, r.FeedWatchdog(), simulator.ErrNoWatchdog)
+	})
+
+	t.Run("RPMsg heartbeats taken off a vring feed the watchdog", func(t *testing.T) {
+		const rscTableAddr = 0x3000_0000
+		const heartbeatEndpoint = 0x400
+		root := t.TempDir()
+		r := newTestRemoteproc(t, simulator.Config{
+			RootDir:           root,
+			Name:              "dsp0",
+			BootDelay:         time.Millisecond,
+			DeviceMemory:      true,
+			Vrings:            true,
+			MemoryRegions:     []simulator.MemoryRegion{{Name: "rsc", Address: rscTableAddr, Size: 0x1000}},
+			WatchdogTimeout:   timeout,
+			HeartbeatEndpoint: heartbeatEndpoint,
+		})
+		table := testResourceTable(testVdevRsc(7, testVringRsc{da: 0xffffffff, align: 16, num: 4}, testVringRsc{da: 0xffffffff, align: 16, num: 4}))
+		firmware := testELF{
+			segments:          []testSegment{{da: rscTableAddr, data: table}},
+			resourceTable:     table,
+			resourceTableAddr: rscTableAddr,
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(root, "lib", "firmware", "fw.elf"), firmware.build(), 0644))
+		require.NoError(t, r.SetFirmware("fw.elf"))
+		require.NoError(t, r.Start())
+		requireState(t, r, simulator.StateRunning)
+
+		vdev := r.Vdevs()[0]
+		vring := vdev.Vrings[1]
+		driver := testVirtioDriver{t: t, vring: filepath.Join(root, "run", "remoteproc0", "memory", "vdev0vring1@80001000"), num: 4, align: 16}
+		buffers := filepath.Join(root, "run", "remoteproc0", "memory", "vdev0buffer@80002000")
+		heartbeat := make([]byte, 16)
+		binary.LittleEndian.PutUint32(heartbeat[4:], heartbeatEndpoint)
+		writeMemory(t, buffers, 0, heartbeat)
+
+		for i := range 6 {
+			time.Sleep(timeout / 3)
+			driver.setDesc(i%4, vdev.Buffers.DeviceAddress, uint32(len(heartbeat)), 0, 0)
+			driver.makeAvailable(uint16(i % 4))
+			chain, err := vring.Pop()
+			require.NoError(t, err)
+			require.NotNil(t, chain)
+			require.NoError(t, vring.Push(chain, 0))
+		}
+
+		assert.Equal(t, simulator.StateRunning, r.State())
+		requireState(t, r, simulator.StateCrashed)
+		assert.Equal(t, simulator.CrashWatchdog, r.CrashReason())
+	})
+}